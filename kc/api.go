@@ -3,9 +3,11 @@ package kc
 import (
 	"encoding/json"
 	"fmt"
+	"log"
 	"strings"
 	"time"
 
+	kcblock "github.com/alx696/polong-core/kc/block"
 	kcconnstate "github.com/alx696/polong-core/kc/connstate"
 	kccontact "github.com/alx696/polong-core/kc/contact"
 	kcdb "github.com/alx696/polong-core/kc/db"
@@ -26,66 +28,269 @@ func GetState() []byte {
 }
 
 // GetOption 获取设置
+// blacklist_id_array为拒绝名单中的ID(兼容旧版), 详细信息见GetBlockList.
 func GetOption() string {
-	jsonBytes, _ := json.Marshal(kcoption.Get())
+	option := kcoption.Get()
+	option.BlacklistIDArray = blockIDArray()
+	jsonBytes, _ := json.Marshal(option)
 	return string(jsonBytes)
 }
 
 // SetInfo 设置我的名字和头像
 func SetInfo(name, photo string) {
-	option := kcoption.Get()
-	option.Name = name
-	option.Photo = photo
-	kcoption.Set(*option)
+	_, e := kcoption.Update(func(option *kcoption.Option) {
+		option.Name = name
+		option.Photo = photo
+		option.InfoVersion = time.Now().UnixNano()
+	})
+	if e != nil {
+		log.Println("保存我的信息出错", e)
+		return
+	}
+
+	// 推送给联系人
+	go broadcastInfo()
+}
+
+// RekeyDatabase 更换数据库口令(为空时改用节点密钥派生数据库密钥)
+func RekeyDatabase(passphrase string) error {
+	secret, e := databaseSecret(passphrase, h.Peerstore().PrivKey(h.ID()))
+	if e != nil {
+		return e
+	}
+
+	e = kcdb.Rekey(secret)
+	if e != nil {
+		return e
+	}
+
+	databasePassphrase = passphrase
+	return nil
 }
 
 // SetBootstrapArray 设置引导地址
 func SetBootstrapArray(arrayText string) error {
-	option := kcoption.Get()
-	if arrayText == "" {
-		option.BootstrapArray = []string{}
-	} else {
-		option.BootstrapArray = strings.Split(arrayText, ",")
+	array := []string{}
+	if arrayText != "" {
+		array = strings.Split(arrayText, ",")
 	}
 
-	for _, v := range option.BootstrapArray {
+	for _, v := range array {
 		//检查地址
 		_, e := multiaddrToAddrInfo(v)
 		if e != nil {
 			return fmt.Errorf("破址错误: %s", v)
 		}
+	}
 
-		go connectBootstrap(v)
+	_, e := kcoption.Update(func(option *kcoption.Option) {
+		option.BootstrapArray = array
+	})
+	if e != nil {
+		return e
 	}
 
-	kcoption.Set(*option)
+	go bootstrapCheck(true)
 	return nil
 }
 
-// SetBlacklistIDArray 设置黑名单
+// SetRetentionDays 设置会话消息保留天数(全局), 0表示永久保留
+func SetRetentionDays(days int64) error {
+	if days < 0 {
+		return fmt.Errorf("天数错误")
+	}
+
+	_, e := kcoption.Update(func(option *kcoption.Option) {
+		option.RetentionDays = days
+	})
+	return e
+}
+
+// SetPrivacyMode 设置隐私模式(open, contact, allowlist)
+func SetPrivacyMode(mode string) error {
+	switch mode {
+	case kcoption.PrivacyModeOpen, kcoption.PrivacyModeContact, kcoption.PrivacyModeAllowlist:
+	default:
+		return fmt.Errorf("隐私模式错误: %s", mode)
+	}
+
+	_, e := kcoption.Update(func(option *kcoption.Option) {
+		option.PrivacyMode = mode
+	})
+	return e
+}
+
+// SetAllowlistIDArray 设置允许名单(隐私模式为allowlist时使用)
+func SetAllowlistIDArray(arrayText string) error {
+	array := []string{}
+	if arrayText != "" {
+		array = strings.Split(arrayText, ",")
+	}
+
+	for _, v := range array {
+		e := IDValid(v)
+		if e != nil {
+			return fmt.Errorf("ID错误: %s", v)
+		}
+	}
+
+	_, e := kcoption.Update(func(option *kcoption.Option) {
+		option.AllowlistIDArray = array
+	})
+	return e
+}
+
+// SetBlacklistIDArray 设置黑名单(兼容旧版接口)
+// 新加入的节点永久拒绝并删除联系人和会话消息, 不在数组中的节点移出拒绝名单.
+// 建议使用BlockPeer和UnblockPeer.
 func SetBlacklistIDArray(arrayText string) {
-	option := kcoption.Get()
-	if arrayText == "" {
-		option.BlacklistIDArray = []string{}
-	} else {
-		option.BlacklistIDArray = strings.Split(arrayText, ",")
+	array := []string{}
+	if arrayText != "" {
+		array = strings.Split(arrayText, ",")
+	}
+
+	for _, v := range blockIDArray() {
+		if !valueInArray(v, array) {
+			e := UnblockPeer(v)
+			if e != nil {
+				log.Println("移出拒绝名单出错", e)
+			}
+		}
 	}
-	kcoption.Set(*option)
 
-	for _, v := range option.BlacklistIDArray {
-		if kccontact.Has(v) {
-			DelContact(v)
+	now := time.Now().UnixNano() / 1e6
+	for _, v := range array {
+		if kcblock.Has(v, now) {
+			continue
+		}
+		_, e := BlockPeer(v, "", 0, false)
+		if e != nil {
+			log.Println("加入拒绝名单出错", v, e)
 		}
 	}
 }
 
 // GetContact 获取联系人Map
+// 包含最后在线时间和最近的在线记录, 见ContactDetail.
 func GetContact() string {
-	jsonBytes, _ := json.Marshal(kccontact.GetMap())
+	data := make(map[string]ContactDetail)
+	for k, v := range *kccontact.GetMap() {
+		data[k] = contactDetail(v)
+	}
+	jsonBytes, _ := json.Marshal(data)
+	return string(jsonBytes)
+}
+
+// FindContact 按标签和收藏筛选联系人Map(tag为空时不按标签筛选)
+func FindContact(tag string, favoriteOnly bool) string {
+	data := make(map[string]kccontact.Contact)
+	for k, v := range *kccontact.GetMap() {
+		if tag != "" && !v.HasTag(tag) {
+			continue
+		}
+		if favoriteOnly && !v.Favorite {
+			continue
+		}
+		data[k] = v
+	}
+
+	jsonBytes, _ := json.Marshal(data)
 	return string(jsonBytes)
 }
 
+// GetContactTags 获取所有标签及其联系人数量Map
+func GetContactTags() string {
+	jsonBytes, _ := json.Marshal(kccontact.TagCount())
+	return string(jsonBytes)
+}
+
+// SetContactTags 设置联系人标签(多个用","分隔, 为空时清除)
+func SetContactTags(id, tagsText string) error {
+	var tags []string
+	for _, v := range strings.Split(tagsText, ",") {
+		tag := strings.TrimSpace(v)
+		if tag != "" && !valueInArray(tag, tags) {
+			tags = append(tags, tag)
+		}
+	}
+
+	return updateContact(id, func(data *kccontact.Contact) {
+		data.Tags = tags
+	})
+}
+
+// AddContactTag 给联系人添加标签
+func AddContactTag(id, tag string) error {
+	tag = strings.TrimSpace(tag)
+	if tag == "" || strings.Contains(tag, ",") {
+		return fmt.Errorf("标签错误")
+	}
+
+	return updateContact(id, func(data *kccontact.Contact) {
+		if !data.HasTag(tag) {
+			data.Tags = append(data.Tags, tag)
+		}
+	})
+}
+
+// RemoveContactTag 移除联系人标签
+func RemoveContactTag(id, tag string) error {
+	return updateContact(id, func(data *kccontact.Contact) {
+		data.Tags = removeValueFromArray(tag, data.Tags)
+	})
+}
+
+// RenameContactTag 重命名所有联系人中的标签
+func RenameContactTag(oldTag, newTag string) error {
+	newTag = strings.TrimSpace(newTag)
+	if newTag == "" || strings.Contains(newTag, ",") {
+		return fmt.Errorf("标签错误")
+	}
+
+	for _, v := range *kccontact.Values() {
+		if !v.HasTag(oldTag) {
+			continue
+		}
+
+		e := updateContact(v.ID, func(data *kccontact.Contact) {
+			data.Tags = removeValueFromArray(oldTag, data.Tags)
+			if !data.HasTag(newTag) {
+				data.Tags = append(data.Tags, newTag)
+			}
+		})
+		if e != nil {
+			return e
+		}
+	}
+
+	return nil
+}
+
+// DeleteContactTag 从所有联系人中删除标签
+func DeleteContactTag(tag string) error {
+	for _, v := range *kccontact.Values() {
+		if !v.HasTag(tag) {
+			continue
+		}
+
+		e := RemoveContactTag(v.ID, tag)
+		if e != nil {
+			return e
+		}
+	}
+
+	return nil
+}
+
+// SetContactFavorite 设置联系人收藏
+func SetContactFavorite(id string, favorite bool) error {
+	return updateContact(id, func(data *kccontact.Contact) {
+		data.Favorite = favorite
+	})
+}
+
 // NewContact 添加(更新)联系人
+// 对方还不是联系人时需要对方同意, 此时返回联系请求(direction为out).
 func NewContact(id string) (string, error) {
 	if id == "" {
 		return "", fmt.Errorf("id没设")
@@ -102,6 +307,17 @@ func NewContact(id string) (string, error) {
 
 	// 交换信息
 	info, e := exchangeInfo(id)
+	if e == errWaitingApproval {
+		// 保存我的请求, 对方同意后会发起交换信息
+		request := kccontact.Request{ID: id, Direction: kccontact.RequestDirectionOut, Time: time.Now().UnixNano() / 1e6}
+		e = kccontact.SetRequest(request)
+		if e != nil {
+			return "", e
+		}
+
+		jsonBytes, _ := json.Marshal(request)
+		return string(jsonBytes), nil
+	}
 	if e != nil {
 		return "", e
 	}
@@ -111,9 +327,57 @@ func NewContact(id string) (string, error) {
 	return string(jsonBytes), nil
 }
 
+// GetContactRequest 获取联系请求数组
+func GetContactRequest() string {
+	jsonBytes, _ := json.Marshal(kccontact.Requests())
+	return string(jsonBytes)
+}
+
+// AcceptContact 同意对方的联系请求, 返回联系人
+func AcceptContact(id string) (string, error) {
+	request, exists := kccontact.GetRequest(id)
+	if !exists || request.Direction != kccontact.RequestDirectionIn {
+		return "", fmt.Errorf("没有对方的联系请求")
+	}
+
+	contact, e := kccontact.SetRemote(kccontact.Contact{ID: request.ID, Name: request.Name, Photo: request.Photo, PhotoHash: avatarHash(request.Photo)})
+	if e != nil {
+		return "", e
+	}
+	kccontact.DelRequest(id)
+
+	// 执行订阅回调
+	jsonBytes, _ := json.Marshal(*contact)
+	feedCallback.FeedCallbackOnContactUpdate(string(jsonBytes))
+
+	// 交换信息通知对方(对方因为请求过我会自动保存)
+	go func() {
+		_, e := exchangeInfo(id)
+		if e != nil {
+			log.Println("同意联系请求后交换信息失败", e)
+		}
+	}()
+
+	return string(jsonBytes), nil
+}
+
+// RejectContact 拒绝对方的联系请求(也可以取消我的请求)
+func RejectContact(id string) error {
+	_, exists := kccontact.GetRequest(id)
+	if !exists {
+		return fmt.Errorf("没有联系请求")
+	}
+
+	return kccontact.DelRequest(id)
+}
+
 // DelContact 删除联系人
 func DelContact(id string) {
 	DeleteChatMessageByPeerID(id)
+	kcdb.ChatSettingDelete(id)
+	kcdb.PresenceDeleteByPeerID(id)
+	kcdb.PeerAddrDeleteByPeerID(id)
+	reconnectForget(id)
 	kccontact.Del(id)
 
 	// 执行订阅回调
@@ -122,18 +386,17 @@ func DelContact(id string) {
 
 // SetContactNameRemark 设置联系人名字备注
 func SetContactNameRemark(id, name string) {
-	data := kccontact.Get(id)
-	data.NameRemark = name
-	kccontact.Set(*data)
-
-	// 执行订阅回调
-	jsonBytes, _ := json.Marshal(*data)
-	feedCallback.FeedCallbackOnContactUpdate(string(jsonBytes))
+	e := updateContact(id, func(data *kccontact.Contact) {
+		data.NameRemark = name
+	})
+	if e != nil {
+		log.Println("设置联系人名字备注出错", e)
+	}
 }
 
 // SendChatMessageText 发送会话消息文本
 func SendChatMessageText(peerID, text string) {
-	m := kcdb.ChatMessageInfo{ID: time.Now().UnixNano(), FromPeerID: h.ID().Pretty(), ToPeerID: peerID, Text: text, State: "发送", Read: true}
+	m := kcdb.ChatMessageInfo{ID: time.Now().UnixNano(), FromPeerID: h.ID().Pretty(), ToPeerID: peerID, Text: text, Type: kcdb.ChatMessageTypeText, State: kcdb.ChatMessageStateSend, Read: true}
 
 	go sendChatMessage(&m)
 }
@@ -142,7 +405,7 @@ func SendChatMessageText(peerID, text string) {
 func SendChatMessageFile(peerID, filePath, fileName, fileExtension string, fileSize int64) {
 	m := kcdb.ChatMessageInfo{ID: time.Now().UnixNano(), FromPeerID: h.ID().Pretty(), ToPeerID: peerID,
 		FilePath: filePath, FileName: fileName, FileExtension: fileExtension, FileSize: fileSize,
-		State: "发送", Read: true}
+		Type: kcdb.ChatMessageTypeFile, State: kcdb.ChatMessageStateSend, Read: true}
 
 	go sendChatMessage(&m)
 }
@@ -162,33 +425,114 @@ func FindChatMessage(peerID string) (string, error) {
 	return string(jsonBytes), nil
 }
 
-// DeleteChatMessageByPeerID 通过节点ID删除会话消息
+// DeleteChatMessageByPeerID 通过节点ID删除会话消息(不删除文件)
 func DeleteChatMessageByPeerID(peerID string) {
-	//array, _ := kcdb.ChatMessageInfoFind(peerID)
-	//for _, v := range *array {
-	//	if v.FileSize > 0 {
-	//		os.Remove(v.FilePath)
-	//	}
-	//}
+	kcdb.ChatMessageInfoDeleteByPeerID(peerID)
+}
+
+// DeleteChatMessageAndFileByPeerID 通过节点ID删除会话消息, 同时删除文件目录中的文件
+func DeleteChatMessageAndFileByPeerID(peerID string) {
+	array, e := kcdb.ChatMessageInfoFind(peerID)
+	if e != nil {
+		log.Println(e)
+		return
+	}
+	for _, v := range *array {
+		removeChatMessageFile(&v)
+	}
 
 	kcdb.ChatMessageInfoDeleteByPeerID(peerID)
 }
 
-// DeleteChatMessageByID 通过ID删除会话消息
+// DeleteChatMessageByID 通过ID删除会话消息(不删除文件)
 func DeleteChatMessageByID(id int64) {
-	//m, e := kcdb.ChatMessageInfoGet(id)
-	//if e != nil {
-	//	log.Println(e)
-	//	return
-	//}
-	//
-	//if m.FileSize > 0 {
-	//	os.Remove(m.FilePath)
-	//}
+	kcdb.ChatMessageInfoDeleteByID(id)
+}
+
+// DeleteChatMessageAndFileByID 通过ID删除会话消息, 同时删除文件目录中的文件
+func DeleteChatMessageAndFileByID(id int64) {
+	m, e := kcdb.ChatMessageInfoGet(id)
+	if e != nil {
+		log.Println(e)
+		return
+	}
+	removeChatMessageFile(m)
 
 	kcdb.ChatMessageInfoDeleteByID(id)
 }
 
+// CollectOrphanFiles 查找文件目录中没有被会话消息引用的文件(remove为真时删除), 返回文件路径数组
+// 注意: 最近一小时内修改的文件不处理, 防止删除刚上传还没有发送的文件.
+func CollectOrphanFiles(remove bool) (string, error) {
+	array, e := collectOrphanFiles(remove, time.Hour)
+	if e != nil {
+		return "", e
+	}
+
+	if len(array) == 0 {
+		return "[]", nil
+	}
+
+	jsonBytes, _ := json.Marshal(array)
+	return string(jsonBytes), nil
+}
+
+// GetChatSetting 获取会话设置
+func GetChatSetting(peerID string) (string, error) {
+	setting, e := kcdb.ChatSettingGet(peerID)
+	if e != nil {
+		return "", e
+	}
+
+	jsonBytes, _ := json.Marshal(*setting)
+	return string(jsonBytes), nil
+}
+
+// SetChatRetentionDays 设置会话消息保留天数(仅本地), 0表示使用全局设置
+func SetChatRetentionDays(peerID string, days int64) error {
+	if days < 0 {
+		return fmt.Errorf("天数错误")
+	}
+
+	setting, e := kcdb.ChatSettingGet(peerID)
+	if e != nil {
+		return e
+	}
+	setting.RetentionDays = days
+	return kcdb.ChatSettingSet(setting)
+}
+
+// SetChatDisappearSeconds 设置阅后即焚秒数, 0表示关闭
+// 注意: 对方确认后才会保存, 对方不在线时返回错误.
+func SetChatDisappearSeconds(peerID string, seconds int64) error {
+	if seconds < 0 {
+		return fmt.Errorf("秒数错误")
+	}
+
+	setting, e := kcdb.ChatSettingGet(peerID)
+	if e != nil {
+		return e
+	}
+
+	e = sendMessageSetting(peerID, chatSettingMessage{DisappearSeconds: seconds})
+	if e != nil {
+		return e
+	}
+
+	setting.DisappearSeconds = seconds
+	return kcdb.ChatSettingSet(setting)
+}
+
+// ExportConversation 导出指定节点会话到目录, 格式为json或html(同时保存JSON用于导入), 文件复制到目录中
+func ExportConversation(peerID, format, path string) error {
+	return exportConversation(peerID, format, path)
+}
+
+// ImportConversation 从存档目录(或JSON文件)导入会话, 已经存在的消息不会重复导入, 返回导入数量
+func ImportConversation(path string) (int, error) {
+	return importConversation(path)
+}
+
 // GetConnectedPeerIDs 获取连接状态节点ID
 func GetConnectedPeerIDs() string {
 	ids := kcconnstate.TrueKeys()
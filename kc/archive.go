@@ -0,0 +1,279 @@
+package kc
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	kccontact "github.com/alx696/polong-core/kc/contact"
+	kcdb "github.com/alx696/polong-core/kc/db"
+	kcoption "github.com/alx696/polong-core/kc/option"
+)
+
+const (
+	// 存档格式: JSON
+	ArchiveFormatJSON = "json"
+	// 存档格式: HTML
+	ArchiveFormatHTML = "html"
+
+	// 存档JSON文件名
+	archiveJSONName = "conversation.json"
+	// 存档HTML文件名
+	archiveHTMLName = "index.html"
+	// 存档文件目录名
+	archiveFileDirectoryName = "file"
+)
+
+// ConversationArchive 会话存档
+type ConversationArchive struct {
+	// 导出时我的节点ID
+	SelfID string `json:"selfID"`
+	// 对方节点ID
+	PeerID string `json:"peerID"`
+	// 导出时间(毫秒)
+	Time int64 `json:"time"`
+	// 会话消息, 文件路径为存档目录中的相对路径
+	Messages []kcdb.ChatMessageInfo `json:"messages"`
+}
+
+// HTML存档模板
+var archiveHTMLTemplate = template.Must(template.New("archive").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.PeerName}}</title>
+<style>
+body{font-family:sans-serif;max-width:800px;margin:0 auto;padding:16px;background:#f5f5f5}
+.m{margin:8px 0;padding:8px 12px;border-radius:6px;background:#fff;max-width:70%;word-wrap:break-word}
+.self{margin-left:auto;background:#dcf8c6}
+.t{font-size:12px;color:#888}
+img{max-width:100%}
+</style>
+</head>
+<body>
+<h3>{{.PeerName}}</h3>
+{{range .Messages}}<div class="m{{if .Self}} self{{end}}">
+<div class="t">{{.Name}} {{.Time}}</div>
+{{if .FilePath}}{{if .Image}}<img src="{{.FilePath}}" alt="{{.FileName}}">{{else}}<a href="{{.FilePath}}">{{.FileName}}</a>{{end}}{{else}}<div>{{.Text}}</div>{{end}}
+</div>
+{{end}}
+</body>
+</html>
+`))
+
+// HTML存档消息
+type archiveHTMLMessage struct {
+	Self     bool
+	Name     string
+	Time     string
+	Text     string
+	FilePath string
+	FileName string
+	Image    bool
+}
+
+// 复制文件
+func copyFile(sourcePath, targetPath string) error {
+	source, e := os.Open(sourcePath)
+	if e != nil {
+		return e
+	}
+	defer source.Close()
+
+	target, e := os.Create(targetPath)
+	if e != nil {
+		return e
+	}
+	defer target.Close()
+
+	_, e = io.Copy(target, source)
+	if e != nil {
+		return e
+	}
+
+	return target.Sync()
+}
+
+// 写入HTML存档
+func writeArchiveHTML(archive *ConversationArchive, path string) error {
+	peerName := archive.PeerID
+	if kccontact.Has(archive.PeerID) {
+		contact := kccontact.Get(archive.PeerID)
+		peerName = contact.Name
+		if contact.NameRemark != "" {
+			peerName = contact.NameRemark
+		}
+	}
+	selfName := kcoption.Get().Name
+
+	var messages []archiveHTMLMessage
+	for _, m := range archive.Messages {
+		message := archiveHTMLMessage{
+			Self:     m.FromPeerID == archive.SelfID,
+			Name:     peerName,
+			Time:     time.Unix(0, m.ID).Format("2006-01-02 15:04:05"),
+			Text:     m.Text,
+			FilePath: m.FilePath,
+			FileName: m.FileName,
+		}
+		if message.Self {
+			message.Name = selfName
+		}
+		if m.Type == kcdb.ChatMessageTypeContact {
+			var card ContactCard
+			if json.Unmarshal([]byte(m.Text), &card) == nil {
+				message.Text = fmt.Sprintf("名片: %s (%s)", card.Name, card.ID)
+			}
+		}
+		switch strings.ToLower(m.FileExtension) {
+		case "jpg", "jpeg", "png", "gif", "webp", "bmp":
+			message.Image = true
+		}
+		messages = append(messages, message)
+	}
+
+	f, e := os.Create(filepath.Join(path, archiveHTMLName))
+	if e != nil {
+		return e
+	}
+	defer f.Close()
+
+	return archiveHTMLTemplate.Execute(f, map[string]interface{}{"PeerName": peerName, "Messages": messages})
+}
+
+// 导出会话到目录(文件复制到目录中)
+func exportConversation(peerID, format, path string) error {
+	if format != ArchiveFormatJSON && format != ArchiveFormatHTML {
+		return fmt.Errorf("不支持的格式: %s", format)
+	}
+
+	array, e := kcdb.ChatMessageInfoFind(peerID)
+	if e != nil {
+		return e
+	}
+
+	fileDirectoryPath := filepath.Join(path, archiveFileDirectoryName)
+	e = os.MkdirAll(fileDirectoryPath, os.ModePerm)
+	if e != nil {
+		return e
+	}
+
+	archive := ConversationArchive{SelfID: h.ID().Pretty(), PeerID: peerID, Time: time.Now().UnixNano() / 1e6, Messages: []kcdb.ChatMessageInfo{}}
+	for _, m := range *array {
+		if m.FileSize > 0 {
+			// 使用消息ID作为前缀防止重名
+			fileName := fmt.Sprintf("%d_%s", m.ID, filepath.Base(m.FilePath))
+			e = copyFile(m.FilePath, filepath.Join(fileDirectoryPath, fileName))
+			if e != nil {
+				log.Println("导出会话复制文件出错", m.FilePath, e)
+				m.FilePath = ""
+			} else {
+				m.FilePath = filepath.ToSlash(filepath.Join(archiveFileDirectoryName, fileName))
+			}
+		}
+		archive.Messages = append(archive.Messages, m)
+	}
+
+	// HTML格式同时保存JSON, 用于导入
+	if format == ArchiveFormatHTML {
+		e = writeArchiveHTML(&archive, path)
+		if e != nil {
+			return e
+		}
+	}
+
+	jsonBytes, _ := json.MarshalIndent(archive, "", "  ")
+	return ioutil.WriteFile(filepath.Join(path, archiveJSONName), jsonBytes, os.ModePerm)
+}
+
+// 获取存档中文件的路径, 文件路径需要是存档目录中的相对路径
+func archiveFilePath(archiveDirectory, filePath string) (string, error) {
+	cleanPath := filepath.Clean(filepath.FromSlash(filePath))
+	if filepath.IsAbs(cleanPath) || cleanPath == ".." || strings.HasPrefix(cleanPath, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("存档文件路径错误: %s", filePath)
+	}
+
+	sourcePath := filepath.Join(archiveDirectory, cleanPath)
+	if !pathInDirectory(archiveDirectory, sourcePath) {
+		return "", fmt.Errorf("存档文件路径错误: %s", filePath)
+	}
+	return sourcePath, nil
+}
+
+// 导入会话(已经存在的消息不会重复导入), 返回导入数量
+func importConversation(path string) (int, error) {
+	// 路径可以是存档目录或存档JSON文件
+	info, e := os.Stat(path)
+	if e != nil {
+		return 0, e
+	}
+	if info.IsDir() {
+		path = filepath.Join(path, archiveJSONName)
+	}
+	archiveDirectory := filepath.Dir(path)
+
+	jsonBytes, e := ioutil.ReadFile(path)
+	if e != nil {
+		return 0, e
+	}
+	var archive ConversationArchive
+	e = json.Unmarshal(jsonBytes, &archive)
+	if e != nil {
+		return 0, fmt.Errorf("解析存档出错: %w", e)
+	}
+
+	selfID := h.ID().Pretty()
+	var count int
+	for _, m := range archive.Messages {
+		has, e := kcdb.ChatMessageInfoHas(m.ID)
+		if e != nil {
+			return count, e
+		}
+		if has {
+			continue
+		}
+
+		// 换了节点身份时替换为当前ID
+		if archive.SelfID != "" && archive.SelfID != selfID {
+			if m.FromPeerID == archive.SelfID {
+				m.FromPeerID = selfID
+			}
+			if m.ToPeerID == archive.SelfID {
+				m.ToPeerID = selfID
+			}
+		}
+
+		if m.FileSize > 0 && m.FilePath != "" {
+			// 存档中的路径不可信, 只允许存档目录中的文件, 只能复制到文件目录中
+			m.FileName = filepath.Base(m.FileName)
+			sourcePath, e := archiveFilePath(archiveDirectory, m.FilePath)
+			targetPath := uniqueFilePath(fileDirectory, m.FileName)
+			if e == nil && !pathInDirectory(fileDirectory, targetPath) {
+				e = fmt.Errorf("文件名错误: %s", m.FileName)
+			}
+			if e == nil {
+				e = copyFile(sourcePath, targetPath)
+			}
+			if e != nil {
+				log.Println("导入会话复制文件出错", m.FilePath, e)
+				targetPath = ""
+			}
+			m.FilePath = targetPath
+		}
+
+		e = kcdb.ChatMessageInfoInsert(&m)
+		if e != nil {
+			return count, e
+		}
+		count++
+	}
+
+	return count, nil
+}
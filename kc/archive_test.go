@@ -0,0 +1,32 @@
+package kc
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestArchiveFilePath(t *testing.T) {
+	archiveDirectory := filepath.Join(t.TempDir(), "archive")
+
+	tests := []struct {
+		filePath string
+		ok       bool
+	}{
+		{"file/1_a.txt", true},
+		{"file/../file/1_a.txt", true},
+		{"../secret.txt", false},
+		{"file/../../secret.txt", false},
+		{"..", false},
+		{".", false},
+		{"/etc/passwd", false},
+	}
+	for _, v := range tests {
+		path, e := archiveFilePath(archiveDirectory, v.filePath)
+		if (e == nil) != v.ok {
+			t.Errorf("%s: 结果 %s, %v", v.filePath, path, e)
+		}
+		if e == nil && !pathInDirectory(archiveDirectory, path) {
+			t.Errorf("%s: 不在存档目录中 %s", v.filePath, path)
+		}
+	}
+}
@@ -0,0 +1,229 @@
+package kc
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	kccontact "github.com/alx696/polong-core/kc/contact"
+	kcoption "github.com/alx696/polong-core/kc/option"
+	"github.com/libp2p/go-libp2p-core/network"
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+// 头像最大字节数
+const avatarMaxSize = 10 * 1024 * 1024
+
+// 没有头像回复
+const resultNoAvatar = "没有头像"
+
+// 头像缓存目录(安全目录中)
+var avatarDirectory string
+
+// 计算头像哈希(SHA-256十六进制), 没有头像时返回空
+func avatarHash(photo string) string {
+	if photo == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(photo))
+	return hex.EncodeToString(sum[:])
+}
+
+// 头像缓存路径
+func avatarPath(hash string) string {
+	return filepath.Join(avatarDirectory, hash)
+}
+
+// 保存头像缓存, 返回哈希
+func avatarSave(photo string) (string, error) {
+	hash := avatarHash(photo)
+	if hash == "" {
+		return "", nil
+	}
+
+	filePath := avatarPath(hash)
+	if _, e := os.Stat(filePath); e == nil {
+		return hash, nil
+	}
+	e := os.MkdirAll(avatarDirectory, os.ModePerm)
+	if e != nil {
+		return "", e
+	}
+	return hash, ioutil.WriteFile(filePath, []byte(photo), 0600)
+}
+
+// 读取头像缓存, 不存在时返回空
+func avatarLoad(hash string) string {
+	data, e := ioutil.ReadFile(avatarPath(hash))
+	if e != nil || avatarHash(string(data)) != hash {
+		return ""
+	}
+	return string(data)
+}
+
+// 获取用于交换的我的信息
+// 对方支持头像协议时只提供头像哈希, 否则(旧版节点)直接提供头像.
+func myInfo(option *kcoption.Option, peerID peer.ID) kccontact.Contact {
+	info := kccontact.Contact{ID: h.ID().Pretty(), Name: option.Name, PhotoHash: avatarHash(option.Photo), InfoVersion: option.InfoVersion}
+	protocols, _ := h.Peerstore().SupportsProtocols(peerID, protocolIDAvatar)
+	if len(protocols) == 0 {
+		info.Photo = option.Photo
+	}
+	return info
+}
+
+// 补全对方信息中的头像: 对方只提供哈希时从缓存读取, 缓存没有时向对方获取.
+// 获取失败时保留原有头像, 下次交换信息时重试.
+// 注意: 只用于联系人(或我请求过的节点), 不能用于陌生节点.
+func resolveRemoteAvatar(id string, info *kccontact.Contact) {
+	contact := kccontact.Get(id)
+
+	// 头像超过上限时保留原有头像
+	if len(info.Photo) > avatarMaxSize {
+		log.Println("对方头像太大", id, len(info.Photo))
+		info.Photo = contact.Photo
+		info.PhotoHash = contact.PhotoHash
+		return
+	}
+
+	// 旧版节点直接提供头像
+	if info.Photo != "" {
+		hash, e := avatarSave(info.Photo)
+		if e != nil {
+			log.Println("保存头像缓存出错", e)
+		}
+		info.PhotoHash = hash
+		return
+	}
+	if info.PhotoHash == "" {
+		return
+	}
+
+	// 头像没有变化
+	if contact.PhotoHash == info.PhotoHash && contact.Photo != "" {
+		info.Photo = contact.Photo
+		return
+	}
+
+	// 从缓存读取
+	if photo := avatarLoad(info.PhotoHash); photo != "" {
+		info.Photo = photo
+		return
+	}
+
+	// 向对方获取
+	photo, e := fetchAvatar(id, info.PhotoHash)
+	if e != nil {
+		log.Println("获取对方头像出错", id, e)
+		info.Photo = contact.Photo
+		info.PhotoHash = contact.PhotoHash
+		return
+	}
+	_, e = avatarSave(photo)
+	if e != nil {
+		log.Println("保存头像缓存出错", e)
+	}
+	info.Photo = photo
+}
+
+// 处理头像请求
+func avatarStreamHandler(s network.Stream) {
+	remotePeerID := s.Conn().RemotePeer()
+	log.Println("远程节点获取头像:", remotePeerID)
+	defer s.Close()
+
+	// 创建读写器
+	rw := bufio.NewReadWriter(bufio.NewReader(s), bufio.NewWriter(s))
+
+	// 检查访问权限(联系请求也需要显示头像)
+	if result := checkPermission(s, true); result != "" {
+		log.Println("拒绝远程节点:", remotePeerID, result)
+		resultBytes := []byte(result)
+		writeTextToReadWriter(rw, &resultBytes)
+		return
+	}
+
+	// 读取头像哈希
+	requestBytes, e := readTextFromReadWriter(rw)
+	if e != nil {
+		log.Println("读取头像哈希出错", e)
+		return
+	}
+
+	// 只提供我当前的头像
+	photo := kcoption.Get().Photo
+	if photo == "" || avatarHash(photo) != string(*requestBytes) {
+		resultBytes := []byte(resultNoAvatar)
+		writeTextToReadWriter(rw, &resultBytes)
+		return
+	}
+
+	// 写入大小和数据
+	sizeBytes := []byte(strconv.Itoa(len(photo)))
+	e = writeTextToReadWriter(rw, &sizeBytes)
+	if e != nil {
+		log.Println("写入头像大小出错", e)
+		return
+	}
+	_, e = rw.WriteString(photo)
+	if e == nil {
+		e = rw.Flush()
+	}
+	if e != nil {
+		log.Println("写入头像出错", e)
+	}
+}
+
+// 获取对方头像
+func fetchAvatar(id, hash string) (string, error) {
+	s, e := createStream(id, protocolIDAvatar)
+	if e != nil {
+		return "", e
+	}
+	defer s.Close()
+
+	// 创建读写器
+	rw := bufio.NewReadWriter(bufio.NewReader(s), bufio.NewWriter(s))
+
+	// 写入头像哈希
+	hashBytes := []byte(hash)
+	e = writeTextToReadWriter(rw, &hashBytes)
+	if e != nil {
+		return "", e
+	}
+
+	// 接收大小
+	resultBytes, e := readTextFromReadWriter(rw)
+	if e != nil {
+		return "", e
+	}
+	result := string(*resultBytes)
+	if e := refuseError(result); e != nil {
+		return "", e
+	} else if result == resultNoAvatar {
+		return "", fmt.Errorf("对方没有此头像")
+	}
+	size, e := strconv.Atoi(result)
+	if e != nil || size <= 0 || size > avatarMaxSize {
+		return "", fmt.Errorf("头像大小错误: %s", result)
+	}
+
+	// 接收数据
+	data := make([]byte, size)
+	_, e = io.ReadFull(rw, data)
+	if e != nil {
+		return "", e
+	}
+	if avatarHash(string(data)) != hash {
+		return "", fmt.Errorf("头像哈希不一致")
+	}
+
+	return string(data), nil
+}
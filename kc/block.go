@@ -0,0 +1,135 @@
+package kc
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	kcblock "github.com/alx696/polong-core/kc/block"
+	kccontact "github.com/alx696/polong-core/kc/contact"
+	kcdb "github.com/alx696/polong-core/kc/db"
+	kcoption "github.com/alx696/polong-core/kc/option"
+)
+
+// 迁移旧版黑名单到拒绝名单(旧版加入黑名单时会删除联系人和会话消息)
+func migrateBlacklist() error {
+	array := kcoption.Get().BlacklistIDArray
+	if len(array) == 0 {
+		return nil
+	}
+
+	now := time.Now().UnixNano() / 1e6
+	for _, id := range array {
+		if _, exists := kcblock.Get(id); exists {
+			continue
+		}
+		e := kcblock.Set(kcblock.Block{ID: id, Time: now})
+		if e != nil {
+			return e
+		}
+	}
+
+	_, e := kcoption.Update(func(option *kcoption.Option) {
+		option.BlacklistIDArray = []string{}
+	})
+	return e
+}
+
+// 删除到期的拒绝名单条目
+func blockSweep() {
+	array, e := kcblock.DelExpired(time.Now().UnixNano() / 1e6)
+	if e != nil {
+		log.Println("删除到期拒绝名单出错", e)
+	}
+	for _, id := range array {
+		log.Println("拒绝名单到期", id)
+	}
+}
+
+// 获取拒绝名单中的ID(不含到期的)
+func blockIDArray() []string {
+	array := []string{}
+	now := time.Now().UnixNano() / 1e6
+	for _, v := range kcblock.Values() {
+		if !v.Expired(now) {
+			array = append(array, v.ID)
+		}
+	}
+	return array
+}
+
+// BlockPeer 加入拒绝名单, 返回Block
+// expireSeconds为0时永久拒绝; keepHistory为假时删除联系人, 会话消息(包括文件)和会话设置.
+func BlockPeer(id, reason string, expireSeconds int64, keepHistory bool) (string, error) {
+	e := IDValid(id)
+	if e != nil {
+		return "", fmt.Errorf("id错误")
+	}
+	if id == h.ID().Pretty() {
+		return "", fmt.Errorf("不能是自己")
+	}
+	if expireSeconds < 0 {
+		return "", fmt.Errorf("到期时间错误")
+	}
+
+	now := time.Now()
+	data := kcblock.Block{ID: id, Reason: reason, Time: now.UnixNano() / 1e6, KeepHistory: keepHistory}
+	if expireSeconds > 0 {
+		data.Expire = now.Add(time.Duration(expireSeconds)*time.Second).UnixNano() / 1e6
+	}
+	e = kcblock.Set(data)
+	if e != nil {
+		return "", e
+	}
+
+	// 断开现有连接
+	closePeer(id)
+
+	// 删除联系请求
+	if _, exists := kccontact.GetRequest(id); exists {
+		kccontact.DelRequest(id)
+	}
+
+	// 删除会话消息(包括非联系人的)和联系人
+	if !keepHistory {
+		DeleteChatMessageAndFileByPeerID(id)
+		kcdb.ChatSettingDelete(id)
+		if kccontact.Has(id) {
+			DelContact(id)
+		}
+	}
+
+	jsonBytes, _ := json.Marshal(data)
+	return string(jsonBytes), nil
+}
+
+// UnblockPeer 移出拒绝名单
+func UnblockPeer(id string) error {
+	return kcblock.Del(id)
+}
+
+// GetBlockList 获取拒绝名单数组(按加入时间排序)
+func GetBlockList() string {
+	jsonBytes, _ := json.Marshal(kcblock.Values())
+	return string(jsonBytes)
+}
+
+// GetBlockAttempt 获取拒绝记录数组(按时间倒序, peerID为空时获取所有节点)
+// 对方连接时protocol为connection, 同一节点每分钟最多记录一次.
+func GetBlockAttempt(peerID string, limit int) (string, error) {
+	if limit <= 0 {
+		limit = 100
+	}
+	array, e := kcdb.BlockAttemptFind(peerID, limit)
+	if e != nil {
+		return "", e
+	}
+	jsonBytes, _ := json.Marshal(array)
+	return string(jsonBytes), nil
+}
+
+// DeleteBlockAttempt 删除拒绝记录(peerID为空时删除所有)
+func DeleteBlockAttempt(peerID string) error {
+	return kcdb.BlockAttemptDelete(peerID)
+}
@@ -0,0 +1,132 @@
+package block
+
+import (
+	"encoding/json"
+	"sort"
+	"sync"
+
+	kcdb "github.com/alx696/polong-core/kc/db"
+)
+
+// Block 拒绝名单条目
+type Block struct {
+	ID          string `json:"id"`
+	Reason      string `json:"reason"`       //原因(仅本地)
+	Time        int64  `json:"time"`         //加入时间(毫秒)
+	Expire      int64  `json:"expire"`       //到期时间(毫秒), 0表示永久
+	KeepHistory bool   `json:"keep_history"` //是否保留联系人和会话消息
+}
+
+// 数据库存储分组
+const storeBucket = "block"
+
+var sm sync.RWMutex
+var dm map[string]Block
+
+// New 创建存储(数据库需要先打开)
+func New() error {
+	sm.Lock()
+	defer sm.Unlock()
+
+	dm = make(map[string]Block)
+
+	valueMap, e := kcdb.StoreFind(storeBucket)
+	if e != nil {
+		return e
+	}
+	for k, v := range valueMap {
+		var data Block
+		e = json.Unmarshal([]byte(v), &data)
+		if e != nil {
+			return e
+		}
+		dm[k] = data
+	}
+
+	return nil
+}
+
+// Expired 是否已经到期(now为毫秒)
+func (b *Block) Expired(now int64) bool {
+	return b.Expire > 0 && b.Expire <= now
+}
+
+// Set 设置
+func Set(data Block) error {
+	sm.Lock()
+	defer sm.Unlock()
+
+	valueBytes, _ := json.Marshal(data)
+	e := kcdb.StoreSet(storeBucket, data.ID, string(valueBytes))
+	if e != nil {
+		return e
+	}
+
+	dm[data.ID] = data
+	return nil
+}
+
+// Del 删除
+func Del(id string) error {
+	sm.Lock()
+	defer sm.Unlock()
+
+	e := kcdb.StoreDelete(storeBucket, id)
+	if e != nil {
+		return e
+	}
+
+	delete(dm, id)
+	return nil
+}
+
+// Has 是否在拒绝名单中(已经到期的不算, now为毫秒)
+func Has(id string, now int64) bool {
+	sm.RLock()
+	data, exists := dm[id]
+	sm.RUnlock()
+	return exists && !data.Expired(now)
+}
+
+// Get 获取指定(返回的是副本)
+func Get(id string) (*Block, bool) {
+	sm.RLock()
+	data, exists := dm[id]
+	sm.RUnlock()
+	return &data, exists
+}
+
+// Values 获取所有条目(按加入时间排序, 返回的是副本)
+func Values() []Block {
+	array := []Block{}
+	sm.RLock()
+	for _, v := range dm {
+		array = append(array, v)
+	}
+	sm.RUnlock()
+
+	sort.Slice(array, func(i, j int) bool {
+		return array[i].Time < array[j].Time
+	})
+	return array
+}
+
+// DelExpired 删除已经到期的条目, 返回删除的ID
+func DelExpired(now int64) ([]string, error) {
+	sm.Lock()
+	defer sm.Unlock()
+
+	var array []string
+	for k, v := range dm {
+		if !v.Expired(now) {
+			continue
+		}
+		e := kcdb.StoreDelete(storeBucket, k)
+		if e != nil {
+			return array, e
+		}
+		delete(dm, k)
+		array = append(array, k)
+	}
+	return array, nil
+}
@@ -0,0 +1,207 @@
+package kc
+
+import (
+	"encoding/json"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	kcoption "github.com/alx696/polong-core/kc/option"
+)
+
+// 内置引导地址(可以通过选项禁用, 私有网络中不使用)
+var defaultBootstrapArray = []string{
+	"/ip4/104.131.131.82/tcp/4001/p2p/QmaCpDMGvV2BGHeYERUEnRQAwe3N8SzbUtfsmvsqQLuvuJ",
+	"/ip4/47.74.132.162/udp/6666/quic/p2p/12D3KooWFTmSZHheeSjGvnZzBtK7UHQUQTA3XNjMwz5bEqTTRPPA",
+	// 注意：android中使用dnsaddr时无法连接。
+	// "/dnsaddr/bootstrap.pl.app.lilu.red/p2p/12D3KooWFTmSZHheeSjGvnZzBtK7UHQUQTA3XNjMwz5bEqTTRPPA",
+}
+
+const (
+	// 引导重试最小间隔
+	bootstrapRetryMin = time.Second * 30
+	// 引导重试最大间隔
+	bootstrapRetryMax = time.Minute * 30
+)
+
+// BootstrapState 引导地址状态
+type BootstrapState struct {
+	Address string `json:"address"`
+	// 是否是内置引导地址
+	Default bool `json:"default"`
+	// 是否已经连接
+	Connected bool `json:"connected"`
+	// 最后连接成功时间(毫秒)
+	LastConnectTime int64 `json:"last_connect_time"`
+	// 最后失败原因
+	LastError string `json:"last_error"`
+	// 连续失败次数
+	FailCount int `json:"fail_count"`
+	// 下次重试时间(毫秒)
+	NextRetryTime int64 `json:"next_retry_time"`
+	// 是否正在连接
+	dialing bool
+}
+
+var bootstrapStateLock sync.Mutex
+var bootstrapStateMap = make(map[string]*BootstrapState)
+
+// 引导重复器信道
+var bootstrapTickerStopChan = make(chan bool, 1)
+
+// 引导重复器
+var bootstrapTicker *time.Ticker
+
+// 引导重复器任务
+func bootstrapTickerTask() {
+	for {
+		select {
+		case <-bootstrapTickerStopChan:
+			log.Println("引导重复器任务结束")
+			return
+		case <-bootstrapTicker.C:
+			bootstrapCheck(false)
+		}
+	}
+}
+
+// 获取当前使用的引导地址(内置地址在前)
+func bootstrapAddrArray() (defaultArray, customArray []string) {
+	option := kcoption.Get()
+	if !option.DefaultBootstrapDisabled && !privateNetwork {
+		defaultArray = defaultBootstrapArray
+	}
+	for _, v := range option.BootstrapArray {
+		if !valueInArray(v, defaultArray) {
+			customArray = append(customArray, v)
+		}
+	}
+	return
+}
+
+// 计算重试间隔(指数退避)
+func bootstrapRetryDelay(failCount int) time.Duration {
+	delay := bootstrapRetryMin
+	for i := 1; i < failCount && delay < bootstrapRetryMax; i++ {
+		delay *= 2
+	}
+	if delay > bootstrapRetryMax {
+		delay = bootstrapRetryMax
+	}
+	return delay
+}
+
+// 检查引导地址, 没有连接且到达重试时间的进行连接(force为真时立即重试所有没有连接的地址)
+func bootstrapCheck(force bool) {
+	defaultArray, customArray := bootstrapAddrArray()
+	now := time.Now()
+
+	bootstrapStateLock.Lock()
+	defer bootstrapStateLock.Unlock()
+
+	// 移除不再使用的地址
+	for k := range bootstrapStateMap {
+		if !valueInArray(k, defaultArray) && !valueInArray(k, customArray) {
+			delete(bootstrapStateMap, k)
+		}
+	}
+
+	for _, array := range [][]string{defaultArray, customArray} {
+		for _, address := range array {
+			state, exists := bootstrapStateMap[address]
+			if !exists {
+				state = &BootstrapState{Address: address}
+				bootstrapStateMap[address] = state
+			}
+			state.Default = valueInArray(address, defaultArray)
+
+			// 检查现有连接
+			addrInfo, e := multiaddrToAddrInfo(address)
+			if e != nil {
+				state.LastError = e.Error()
+				continue
+			}
+			state.Connected = len(h.Network().ConnsToPeer(addrInfo.ID)) > 0
+			if state.Connected || state.dialing {
+				continue
+			}
+			if !force && now.UnixNano()/1e6 < state.NextRetryTime {
+				continue
+			}
+
+			state.dialing = true
+			go bootstrapDial(address)
+		}
+	}
+}
+
+// 连接引导地址并更新状态
+func bootstrapDial(address string) {
+	e := connectBootstrap(address)
+	now := time.Now()
+
+	bootstrapStateLock.Lock()
+	defer bootstrapStateLock.Unlock()
+
+	state, exists := bootstrapStateMap[address]
+	if !exists {
+		return
+	}
+	state.dialing = false
+	if e != nil {
+		state.Connected = false
+		state.LastError = e.Error()
+		state.FailCount++
+		state.NextRetryTime = now.Add(bootstrapRetryDelay(state.FailCount)).UnixNano() / 1e6
+		return
+	}
+	state.Connected = true
+	state.LastConnectTime = now.UnixNano() / 1e6
+	state.LastError = ""
+	state.FailCount = 0
+	state.NextRetryTime = 0
+}
+
+// 获取引导地址状态数组(内置地址在前)
+func bootstrapStateArray() []BootstrapState {
+	bootstrapStateLock.Lock()
+	array := []BootstrapState{}
+	for _, v := range bootstrapStateMap {
+		array = append(array, *v)
+	}
+	bootstrapStateLock.Unlock()
+
+	sort.Slice(array, func(i, j int) bool {
+		if array[i].Default != array[j].Default {
+			return array[i].Default
+		}
+		return array[i].Address < array[j].Address
+	})
+	return array
+}
+
+// GetBootstrapState 获取引导地址状态数组(内置地址在前)
+func GetBootstrapState() string {
+	jsonBytes, _ := json.Marshal(bootstrapStateArray())
+	return string(jsonBytes)
+}
+
+// GetDefaultBootstrapArray 获取内置引导地址数组
+func GetDefaultBootstrapArray() string {
+	jsonBytes, _ := json.Marshal(defaultBootstrapArray)
+	return string(jsonBytes)
+}
+
+// SetDefaultBootstrapDisabled 设置是否禁用内置引导地址(内网部署时禁用)
+func SetDefaultBootstrapDisabled(disabled bool) error {
+	_, e := kcoption.Update(func(option *kcoption.Option) {
+		option.DefaultBootstrapDisabled = disabled
+	})
+	if e != nil {
+		return e
+	}
+
+	go bootstrapCheck(true)
+	return nil
+}
@@ -0,0 +1,27 @@
+package kc
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBootstrapRetryDelay(t *testing.T) {
+	tests := []struct {
+		failCount int
+		want      time.Duration
+	}{
+		{0, bootstrapRetryMin},
+		{1, bootstrapRetryMin},
+		{2, bootstrapRetryMin * 2},
+		{3, bootstrapRetryMin * 4},
+		{6, bootstrapRetryMin * 32},
+		{7, bootstrapRetryMax},
+		{100, bootstrapRetryMax},
+	}
+	for _, v := range tests {
+		got := bootstrapRetryDelay(v.failCount)
+		if got != v.want {
+			t.Errorf("失败%d次: 结果 %s, 应为 %s", v.failCount, got, v.want)
+		}
+	}
+}
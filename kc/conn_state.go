@@ -2,10 +2,14 @@ package kc
 
 import (
 	"log"
+	"sync"
 	"time"
 
+	kcblock "github.com/alx696/polong-core/kc/block"
 	kcconnstate "github.com/alx696/polong-core/kc/connstate"
 	kccontact "github.com/alx696/polong-core/kc/contact"
+	kcdb "github.com/alx696/polong-core/kc/db"
+	"github.com/libp2p/go-libp2p-core/network"
 	"github.com/libp2p/go-libp2p-core/peer"
 )
 
@@ -15,7 +19,12 @@ var connStateTickerStopChan = make(chan bool, 1)
 // 连接状态重复器
 var connStateTicker *time.Ticker
 
+// 节点连接状态更新锁(每个节点一个), 保证读取连接数量, 更新缓存和执行回调按顺序进行
+var connStateLockMap sync.Map
+
 // 连接状态重复器任务
+// 连接状态变化由网络通知立即更新, 这里只作为保障(防止遗漏通知), 并更新最后在线时间,
+// 没有连接的联系人按各自的退避间隔重新连接.
 func connStateTickerTask() {
 	for {
 		select {
@@ -23,8 +32,13 @@ func connStateTickerTask() {
 			log.Println("连接状态重复器任务结束")
 			return
 		case <-connStateTicker.C:
+			now := time.Now().UnixNano() / 1e6
 			for _, id := range *(kccontact.Keys()) {
-				go connStateCheck(id)
+				// 拒绝名单中的联系人(保留记录时)不再连接
+				if kcblock.Has(id, now) {
+					continue
+				}
+				connStateCheck(id)
 			}
 		}
 	}
@@ -38,47 +52,83 @@ func connStateCheck(id string) {
 		return
 	}
 
-	// 获取当前连接状态缓存
-	isConnect, stateError := kcconnstate.Get(id)
-
-	// 获取当前连接数量
-	connCount := len(h.Network().ConnsToPeer(peerID))
-
-	// 更新缓存
-	kcconnstate.Set(id, connCount > 0)
+	// 更新连接状态
+	if connStateUpdate(peerID) {
+		// 当前处于连接状态, 更新最后在线时间
+		e = kcdb.PresenceTouch(id, time.Now().UnixNano()/1e6)
+		if e != nil {
+			log.Println("更新最后在线时间出错", e)
+		}
+		return
+	}
 
-	if connCount == 0 {
-		// 当前处于断开状态
+	// 当前处于断开状态, 到达重试时间时进行连接
+	reconnectPeer(peerID)
+}
 
-		if stateError == nil && isConnect {
-			// 如果之前是连接状态, 则为断开
+// 根据当前连接数量更新联系人连接状态, 变化时执行连接状态变化, 返回是否处于连接状态
+// 同一节点的更新按顺序执行, 最后一次回调总是当前的连接状态.
+func connStateUpdate(peerID peer.ID) bool {
+	id := peerID.Pretty()
+	lock, _ := connStateLockMap.LoadOrStore(id, &sync.Mutex{})
+	lock.(*sync.Mutex).Lock()
+	defer lock.(*sync.Mutex).Unlock()
+
+	isConnect := len(h.Network().ConnsToPeer(peerID)) > 0
+	if kcconnstate.Change(id, isConnect) {
+		if isConnect {
+			log.Println("连接建立", id)
+		} else {
 			log.Println("连接断开", id)
-
-			// 订阅回调
-			feedCallback.FeedCallbackOnPeerConnectState(id, false)
 		}
+		onPeerConnectState(id, isConnect)
+	}
+	return isConnect
+}
 
-		// 进行连接
-		e = connectDHTPeer(peerID)
-		if e != nil {
-			// log.Println("连接失败", id, e)
-		} else {
-			log.Println("连接建立", id)
-
-			// 更新缓存
-			kcconnstate.Set(id, true)
-			// 订阅回调
-			feedCallback.FeedCallbackOnPeerConnectState(id, true)
+// 创建网络通知: 联系人的第一个连接建立或最后一个连接断开时立即更新连接状态
+// 注意: 通知在网络的协程中同步执行, 不能阻塞.
+func connStateNotifiee() network.Notifiee {
+	notify := func(_ network.Network, conn network.Conn) {
+		peerID := conn.RemotePeer()
+		id := peerID.Pretty()
+		if !kccontact.Has(id) || kcblock.Has(id, time.Now().UnixNano()/1e6) {
+			return
 		}
+		// 在协程中按照当前连接数量更新(同一节点的更新有锁保证顺序)
+		go connStateUpdate(peerID)
+	}
+	return &network.NotifyBundle{
+		ConnectedF:    notify,
+		DisconnectedF: notify,
+	}
+}
+
+// 连接状态变化: 记录在线记录, 执行订阅回调
+func onPeerConnectState(id string, isConnect bool) {
+	now := time.Now().UnixNano() / 1e6
+	var e error
+	if isConnect {
+		e = kcdb.PresenceConnect(id, now)
 	} else {
-		// 当前处于连接状态
+		e = kcdb.PresenceDisconnect(id, now)
+	}
+	if e != nil {
+		log.Println("保存在线记录出错", e)
+	}
 
-		if stateError != nil || !isConnect {
-			// 如果之前(没有状态)是非连接状态, 则为连上
-			log.Println("连接建立", id)
+	// 订阅回调
+	feedCallback.FeedCallbackOnPeerConnectState(id, isConnect)
+
+	if isConnect {
+		reconnectReset(id)
 
-			// 订阅回调
-			feedCallback.FeedCallbackOnPeerConnectState(id, true)
+		// 保存连接成功的地址, 下次启动时优先使用
+		if peerID, e := peer.Decode(id); e == nil {
+			go savePeerAddrSuccess(peerID)
 		}
+
+		// 推送离线期间修改的我的信息
+		go pushInfoIfStale(id)
 	}
 }
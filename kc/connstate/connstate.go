@@ -28,6 +28,15 @@ func Get(k string) (bool, error) {
 	return data, nil
 }
 
+// Change 设置, 返回值是否变化(没有时视为false)
+func Change(k string, v bool) bool {
+	sm.Lock()
+	defer sm.Unlock()
+	old := dm[k]
+	dm[k] = v
+	return old != v
+}
+
 // Del 删除
 func Del(k string) {
 	sm.Lock()
@@ -2,72 +2,180 @@ package contact
 
 import (
 	"encoding/json"
+	"fmt"
 	"io/ioutil"
 	"os"
 	"sync"
+
+	kcdb "github.com/alx696/polong-core/kc/db"
 )
 
 // Contact 联系人
 type Contact struct {
-	ID         string `json:"id"`
-	Name       string `json:"name"`
-	Photo      string `json:"photo"` //[可选]
-	NameRemark string `json:"nameRemark"`
+	ID         string   `json:"id"`
+	Name       string   `json:"name"`
+	Photo      string   `json:"photo"`               //[可选]
+	PhotoHash  string   `json:"photoHash,omitempty"` //头像哈希, 交换信息时代替头像
+	NameRemark string   `json:"nameRemark"`
+	Tags       []string `json:"tags,omitempty"`     //标签(仅本地)
+	Favorite   bool     `json:"favorite,omitempty"` //收藏(仅本地)
+	// 对方信息版本(对方修改信息时更新), 用于忽略过期的信息
+	InfoVersion int64 `json:"infoVersion,omitempty"`
+	// 对方已经收到的我的信息版本(仅本地), 小于我的信息版本时需要推送
+	SentInfoVersion int64 `json:"sentInfoVersion,omitempty"`
+	// 对方公钥指纹(仅本地)
+	KeyFingerprint string `json:"keyFingerprint,omitempty"`
+	// 是否已经核对安全码(仅本地), 公钥指纹变化时取消
+	Verified bool `json:"verified,omitempty"`
 }
 
+// 数据库存储分组
+const storeBucket = "contact"
+
 var sm sync.RWMutex
 var dm map[string]Contact
-var path string
 
-// 保存
-func save() error {
-	jsonBytes, e := json.Marshal(dm)
+// 从旧版JSON文件导入数据库, 导入后文件重命名为备份
+func importJSON(jsonPath string) error {
+	jsonBytes, e := ioutil.ReadFile(jsonPath)
+	if os.IsNotExist(e) {
+		return nil
+	}
 	if e != nil {
 		return e
 	}
-	e = ioutil.WriteFile(path, jsonBytes, os.ModePerm)
+
+	var jsonMap map[string]Contact
+	e = json.Unmarshal(jsonBytes, &jsonMap)
 	if e != nil {
 		return e
 	}
-	return nil
+
+	valueMap := make(map[string]string)
+	for k, v := range jsonMap {
+		valueBytes, _ := json.Marshal(v)
+		valueMap[k] = string(valueBytes)
+	}
+	e = kcdb.StoreSetMap(storeBucket, valueMap)
+	if e != nil {
+		return e
+	}
+
+	return os.Rename(jsonPath, jsonPath+".imported")
 }
 
-// New 创建存储
-func New(filePath string) error {
+// New 创建存储(数据库需要先打开)
+// jsonPath为旧版JSON文件路径, 文件存在时导入数据库.
+func New(jsonPath string) error {
+	sm.Lock()
+	defer sm.Unlock()
+
 	dm = make(map[string]Contact)
-	path = filePath
 
-	_, e := os.Stat(path)
+	e := importJSON(jsonPath)
 	if e != nil {
-		return save()
+		return fmt.Errorf("导入联系人出错: %w", e)
 	}
 
-	jsonBytes, e := ioutil.ReadFile(path)
+	valueMap, e := kcdb.StoreFind(storeBucket)
 	if e != nil {
 		return e
 	}
-	e = json.Unmarshal(jsonBytes, &dm)
+	for k, v := range valueMap {
+		var data Contact
+		e = json.Unmarshal([]byte(v), &data)
+		if e != nil {
+			return e
+		}
+		dm[k] = data
+	}
+
+	return loadRequest()
+}
+
+// Set 设置
+func Set(data Contact) error {
+	sm.Lock()
+	defer sm.Unlock()
+
+	valueBytes, _ := json.Marshal(data)
+	e := kcdb.StoreSet(storeBucket, data.ID, string(valueBytes))
 	if e != nil {
 		return e
 	}
 
+	dm[data.ID] = data
 	return nil
 }
 
-// Set 设置
-func Set(data Contact) {
+// SetRemote 保存对方提供的信息, 已经存在时保留本地字段(名字备注, 标签, 收藏等)
+// 对方信息版本小于已经保存的版本时不更新名字和头像, 版本为0(旧版节点)时总是更新.
+// info.KeyFingerprint由调用方根据连接填写, 和保存的不一致时取消核对.
+func SetRemote(info Contact) (*Contact, error) {
 	sm.Lock()
+	defer sm.Unlock()
+
+	data, exists := dm[info.ID]
+	if !exists {
+		data = Contact{ID: info.ID}
+	}
+	if info.KeyFingerprint != "" {
+		if data.KeyFingerprint != info.KeyFingerprint {
+			data.Verified = false
+		}
+		data.KeyFingerprint = info.KeyFingerprint
+	}
+	if info.InfoVersion == 0 || info.InfoVersion >= data.InfoVersion {
+		data.Name = info.Name
+		data.Photo = info.Photo
+		data.PhotoHash = info.PhotoHash
+		if info.InfoVersion != 0 {
+			data.InfoVersion = info.InfoVersion
+		}
+	}
+
+	valueBytes, _ := json.Marshal(data)
+	e := kcdb.StoreSet(storeBucket, data.ID, string(valueBytes))
+	if e != nil {
+		return nil, e
+	}
+
 	dm[data.ID] = data
-	sm.Unlock()
-	save()
+	return &data, nil
+}
+
+// Update 在锁内修改并保存指定联系人(不存在时返回错误), 防止并发修改相互覆盖
+func Update(id string, fn func(data *Contact)) (*Contact, error) {
+	sm.Lock()
+	defer sm.Unlock()
+
+	data, exists := dm[id]
+	if !exists {
+		return nil, fmt.Errorf("联系人不存在")
+	}
+	fn(&data)
+	valueBytes, _ := json.Marshal(data)
+	e := kcdb.StoreSet(storeBucket, id, string(valueBytes))
+	if e != nil {
+		return nil, e
+	}
+
+	dm[id] = data
+	return &data, nil
 }
 
 // Del 删除
-func Del(id string) {
+func Del(id string) error {
 	sm.Lock()
+	defer sm.Unlock()
+
+	e := kcdb.StoreDelete(storeBucket, id)
+	if e != nil {
+		return e
+	}
+
 	delete(dm, id)
-	sm.Unlock()
-	save()
+	return nil
 }
 
 // Has 是否存在
@@ -113,10 +221,36 @@ func Values() *[]Contact {
 	return &array
 }
 
+// HasTag 是否有指定标签
+func (c *Contact) HasTag(tag string) bool {
+	for _, v := range c.Tags {
+		if v == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// TagCount 获取所有标签及其联系人数量
+func TagCount() map[string]int {
+	data := make(map[string]int)
+	sm.RLock()
+	for _, v := range dm {
+		for _, tag := range v.Tags {
+			data[tag]++
+		}
+	}
+	sm.RUnlock()
+	return data
+}
+
 // GetMap 获取Map(返回的是副本)
 func GetMap() *map[string]Contact {
+	data := make(map[string]Contact)
 	sm.RLock()
-	data := dm
+	for k, v := range dm {
+		data[k] = v
+	}
 	sm.RUnlock()
 	return &data
 }
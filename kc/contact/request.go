@@ -0,0 +1,102 @@
+package contact
+
+import (
+	"encoding/json"
+	"sort"
+
+	kcdb "github.com/alx696/polong-core/kc/db"
+)
+
+// 联系请求方向
+const (
+	// 对方请求添加我, 等待我同意
+	RequestDirectionIn = "in"
+	// 我请求添加对方, 等待对方同意
+	RequestDirectionOut = "out"
+)
+
+// Request 联系请求
+type Request struct {
+	ID        string `json:"id"`
+	Name      string `json:"name"`
+	Photo     string `json:"photo"`
+	Direction string `json:"direction"`
+	Time      int64  `json:"time"` //毫秒
+}
+
+// 数据库存储分组
+const requestStoreBucket = "contact_request"
+
+var requestMap map[string]Request
+
+// 加载联系请求(在New中调用, 已经加锁)
+func loadRequest() error {
+	requestMap = make(map[string]Request)
+
+	valueMap, e := kcdb.StoreFind(requestStoreBucket)
+	if e != nil {
+		return e
+	}
+	for k, v := range valueMap {
+		var data Request
+		e = json.Unmarshal([]byte(v), &data)
+		if e != nil {
+			return e
+		}
+		requestMap[k] = data
+	}
+
+	return nil
+}
+
+// SetRequest 保存联系请求
+func SetRequest(data Request) error {
+	sm.Lock()
+	defer sm.Unlock()
+
+	valueBytes, _ := json.Marshal(data)
+	e := kcdb.StoreSet(requestStoreBucket, data.ID, string(valueBytes))
+	if e != nil {
+		return e
+	}
+
+	requestMap[data.ID] = data
+	return nil
+}
+
+// GetRequest 获取联系请求(返回的是副本)
+func GetRequest(id string) (*Request, bool) {
+	sm.RLock()
+	data, exists := requestMap[id]
+	sm.RUnlock()
+	return &data, exists
+}
+
+// DelRequest 删除联系请求
+func DelRequest(id string) error {
+	sm.Lock()
+	defer sm.Unlock()
+
+	e := kcdb.StoreDelete(requestStoreBucket, id)
+	if e != nil {
+		return e
+	}
+
+	delete(requestMap, id)
+	return nil
+}
+
+// Requests 获取所有联系请求(按时间排序, 返回的是副本)
+func Requests() []Request {
+	array := []Request{}
+	sm.RLock()
+	for _, v := range requestMap {
+		array = append(array, v)
+	}
+	sm.RUnlock()
+
+	sort.Slice(array, func(i, j int) bool {
+		return array[i].Time < array[j].Time
+	})
+	return array
+}
@@ -0,0 +1,141 @@
+package kc
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	kccontact "github.com/alx696/polong-core/kc/contact"
+	kcdb "github.com/alx696/polong-core/kc/db"
+	"github.com/libp2p/go-libp2p-core/network"
+)
+
+// ContactCard 联系人名片(会话消息类型为contact时保存在文本中)
+type ContactCard struct {
+	ID        string `json:"id"`
+	Name      string `json:"name"`
+	PhotoHash string `json:"photoHash"`
+}
+
+// 处理名片消息
+func messageContactStreamHandler(s network.Stream) {
+	remotePeerID := s.Conn().RemotePeer()
+	log.Println("远程节点名片消息:", remotePeerID)
+	defer s.Close()
+
+	// 创建读写器
+	rw := bufio.NewReadWriter(bufio.NewReader(s), bufio.NewWriter(s))
+
+	// 检查访问权限
+	if result := checkPermission(s, false); result != "" {
+		log.Println("拒绝远程节点:", remotePeerID, result)
+		resultBytes := []byte(result)
+		writeTextToReadWriter(rw, &resultBytes)
+		return
+	}
+
+	// 读取
+	requestBytes, e := readTextFromReadWriter(rw)
+	if e != nil {
+		log.Println("读取名片消息出错", e)
+		return
+	}
+	var card ContactCard
+	e = json.Unmarshal(*requestBytes, &card)
+	if e == nil {
+		e = IDValid(card.ID)
+	}
+	if e != nil {
+		log.Println("解析名片消息出错", e)
+		return
+	}
+
+	// 回复
+	resultBytes := []byte("收到")
+	e = writeTextToReadWriter(rw, &resultBytes)
+	if e != nil {
+		log.Println("读取名片消息后写入回复时出错", e)
+		return
+	}
+
+	// 保存消息
+	text, _ := json.Marshal(card)
+	chatMessageInfo := kcdb.ChatMessageInfo{ID: time.Now().UnixNano(), FromPeerID: remotePeerID.Pretty(), ToPeerID: h.ID().Pretty(), Text: string(text), Type: kcdb.ChatMessageTypeContact, State: kcdb.ChatMessageStateDone, Progress: 1, Read: false}
+	e = kcdb.ChatMessageInfoInsert(&chatMessageInfo)
+	if e != nil {
+		log.Println("保存消息时出错", e)
+		return
+	}
+
+	// 执行订阅回调
+	jsonBytes, _ := json.Marshal(chatMessageInfo)
+	feedCallback.FeedCallbackOnChatMessage(chatMessageInfo.FromPeerID, string(jsonBytes))
+}
+
+// 发送名片消息
+func sendMessageContact(id, text string) error {
+	s, e := createStream(id, protocolIDMessageContact)
+	if e != nil {
+		return e
+	}
+	defer s.Close()
+
+	// 创建读写器
+	rw := bufio.NewReadWriter(bufio.NewReader(s), bufio.NewWriter(s))
+
+	// 写入
+	data := []byte(text)
+	e = writeTextToReadWriter(rw, &data)
+	if e != nil {
+		return e
+	}
+
+	// 接收
+	resultBytes, e := readTextFromReadWriter(rw)
+	if e != nil {
+		return e
+	}
+
+	// 检查异常状态
+	if e := refuseError(string(*resultBytes)); e != nil {
+		return e
+	}
+
+	return nil
+}
+
+// SendChatMessageContact 发送联系人名片(contactID为要分享的联系人)
+func SendChatMessageContact(peerID, contactID string) error {
+	if !kccontact.Has(contactID) {
+		return fmt.Errorf("联系人不存在")
+	}
+	contact := kccontact.Get(contactID)
+	text, _ := json.Marshal(ContactCard{ID: contact.ID, Name: contact.Name, PhotoHash: contact.PhotoHash})
+
+	m := kcdb.ChatMessageInfo{ID: time.Now().UnixNano(), FromPeerID: h.ID().Pretty(), ToPeerID: peerID, Text: string(text),
+		Type: kcdb.ChatMessageTypeContact, State: kcdb.ChatMessageStateSend, Read: true}
+
+	go sendChatMessage(&m)
+	return nil
+}
+
+// AddContactFromChatMessage 添加名片消息中的联系人(和NewContact相同, 可能返回联系请求)
+func AddContactFromChatMessage(messageID int64) (string, error) {
+	m, e := kcdb.ChatMessageInfoGet(messageID)
+	if e != nil {
+		return "", fmt.Errorf("会话消息不存在")
+	}
+	if m.Type != kcdb.ChatMessageTypeContact {
+		return "", fmt.Errorf("不是名片消息")
+	}
+
+	var card ContactCard
+	e = json.Unmarshal([]byte(m.Text), &card)
+	if e != nil {
+		return "", e
+	}
+
+	return NewContact(card.ID)
+}
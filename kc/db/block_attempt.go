@@ -0,0 +1,58 @@
+package db
+
+// 保留的拒绝记录数量
+const blockAttemptKeepCount = 1000
+
+// BlockAttempt 拒绝记录(拒绝名单中的节点尝试访问)
+type BlockAttempt struct {
+	PeerID   string `json:"peerID"`
+	Time     int64  `json:"time"`     //时间(毫秒)
+	Protocol string `json:"protocol"` //访问的协议
+}
+
+// BlockAttemptInsert 插入拒绝记录
+func BlockAttemptInsert(data *BlockAttempt) error {
+	_, e := db.Exec(`insert into block_attempt values(?, ?, ?)`, data.PeerID, data.Time, data.Protocol)
+	if e != nil {
+		return e
+	}
+
+	// 清理旧记录
+	_, e = db.Exec(`delete from block_attempt where rowid not in (select rowid from block_attempt order by time desc limit ?)`, blockAttemptKeepCount)
+	if e != nil {
+		return e
+	}
+
+	return nil
+}
+
+// BlockAttemptFind 查询拒绝记录(按时间倒序, peerID为空时查询所有节点)
+func BlockAttemptFind(peerID string, limit int) ([]BlockAttempt, error) {
+	array := []BlockAttempt{}
+
+	rows, e := db.Query(`select * from block_attempt where ? = '' or peerID = ? order by time desc limit ?`, peerID, peerID, limit)
+	if e != nil {
+		return nil, e
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var data BlockAttempt
+		e = rows.Scan(&data.PeerID, &data.Time, &data.Protocol)
+		if e != nil {
+			return nil, e
+		}
+		array = append(array, data)
+	}
+
+	return array, nil
+}
+
+// BlockAttemptDelete 删除拒绝记录(peerID为空时删除所有)
+func BlockAttemptDelete(peerID string) error {
+	_, e := db.Exec(`delete from block_attempt where ? = '' or peerID = ?`, peerID, peerID)
+	if e != nil {
+		return e
+	}
+
+	return nil
+}
@@ -0,0 +1,244 @@
+package db
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+const (
+	// 加密字段前缀, 用于区分尚未迁移的明文
+	cipherPrefix = "enc1:"
+	// 密钥校验明文
+	cipherCheckText = "polong"
+)
+
+// 当前使用的加密器
+var aead cipher.AEAD
+
+// 通过秘密和盐派生密钥
+func deriveAEAD(secret, salt []byte) (cipher.AEAD, error) {
+	key, e := scrypt.Key(secret, salt, 32768, 8, 1, 32)
+	if e != nil {
+		return nil, fmt.Errorf("派生密钥出错: %w", e)
+	}
+
+	block, e := aes.NewCipher(key)
+	if e != nil {
+		return nil, e
+	}
+
+	return cipher.NewGCM(block)
+}
+
+// 加密文本(空文本不加密)
+func encryptText(a cipher.AEAD, text string) (string, error) {
+	if text == "" {
+		return "", nil
+	}
+
+	nonce := make([]byte, a.NonceSize())
+	_, e := rand.Read(nonce)
+	if e != nil {
+		return "", e
+	}
+
+	data := a.Seal(nonce, nonce, []byte(text), nil)
+	return cipherPrefix + base64.StdEncoding.EncodeToString(data), nil
+}
+
+// 解密文本(没有加密前缀的原样返回)
+func decryptText(a cipher.AEAD, text string) (string, error) {
+	if !strings.HasPrefix(text, cipherPrefix) {
+		return text, nil
+	}
+
+	data, e := base64.StdEncoding.DecodeString(strings.TrimPrefix(text, cipherPrefix))
+	if e != nil {
+		return "", e
+	}
+	if len(data) < a.NonceSize() {
+		return "", fmt.Errorf("密文长度错误")
+	}
+
+	plainData, e := a.Open(nil, data[:a.NonceSize()], data[a.NonceSize():], nil)
+	if e != nil {
+		return "", fmt.Errorf("解密出错: %w", e)
+	}
+
+	return string(plainData), nil
+}
+
+// 使用新加密器重新加密文本(旧加密器为空时说明原来是明文)
+func recryptText(oldAEAD, newAEAD cipher.AEAD, text string) (string, error) {
+	var e error
+	if oldAEAD != nil {
+		text, e = decryptText(oldAEAD, text)
+		if e != nil {
+			return "", e
+		}
+	} else if strings.HasPrefix(text, cipherPrefix) {
+		return "", fmt.Errorf("已经加密")
+	}
+
+	return encryptText(newAEAD, text)
+}
+
+// 读取元信息(没有时返回空字符串)
+func metaGet(key string) (string, error) {
+	var value string
+	e := db.QueryRow(`select value from meta where key = ?`, key).Scan(&value)
+	if e == sql.ErrNoRows {
+		return "", nil
+	}
+	return value, e
+}
+
+// 写入元信息
+func metaSet(tx *sql.Tx, key, value string) error {
+	_, e := tx.Exec(`insert or replace into meta values(?, ?)`, key, value)
+	return e
+}
+
+// 使用新的加密器重新加密所有会话消息(旧加密器为空时说明原来是明文)
+func reencryptChatMessage(tx *sql.Tx, oldAEAD, newAEAD cipher.AEAD) error {
+	type row struct {
+		id                       int64
+		text, filePath, fileName string
+	}
+
+	rows, e := tx.Query(`select id, text, file_path, file_name from chat_message`)
+	if e != nil {
+		return e
+	}
+	var array []row
+	for rows.Next() {
+		var r row
+		e = rows.Scan(&r.id, &r.text, &r.filePath, &r.fileName)
+		if e != nil {
+			rows.Close()
+			return e
+		}
+		array = append(array, r)
+	}
+	rows.Close()
+
+	for _, r := range array {
+		values := []string{r.text, r.filePath, r.fileName}
+		for i, v := range values {
+			values[i], e = recryptText(oldAEAD, newAEAD, v)
+			if e != nil {
+				return fmt.Errorf("会话消息%d: %w", r.id, e)
+			}
+		}
+
+		_, e = tx.Exec(`update chat_message set text = ?, file_path = ?, file_name = ? where id = ?`,
+			values[0], values[1], values[2], r.id)
+		if e != nil {
+			return e
+		}
+	}
+
+	return nil
+}
+
+// 使用新秘密生成盐和校验值, 并重新加密数据
+func writeCipher(oldAEAD cipher.AEAD, secret []byte) (cipher.AEAD, error) {
+	salt := make([]byte, 16)
+	_, e := rand.Read(salt)
+	if e != nil {
+		return nil, e
+	}
+	newAEAD, e := deriveAEAD(secret, salt)
+	if e != nil {
+		return nil, e
+	}
+	check, e := encryptText(newAEAD, cipherCheckText)
+	if e != nil {
+		return nil, e
+	}
+
+	tx, e := db.Begin()
+	if e != nil {
+		return nil, e
+	}
+	e = reencryptChatMessage(tx, oldAEAD, newAEAD)
+	if e == nil {
+		e = reencryptStore(tx, oldAEAD, newAEAD)
+	}
+	if e == nil {
+		e = metaSet(tx, "cipher_salt", base64.StdEncoding.EncodeToString(salt))
+	}
+	if e == nil {
+		e = metaSet(tx, "cipher_check", check)
+	}
+	if e != nil {
+		tx.Rollback()
+		return nil, e
+	}
+	e = tx.Commit()
+	if e != nil {
+		return nil, e
+	}
+
+	return newAEAD, nil
+}
+
+// 初始化加密(明文数据库自动迁移为加密数据库)
+func openCipher(secret []byte) error {
+	saltText, e := metaGet("cipher_salt")
+	if e != nil {
+		return e
+	}
+
+	// 明文数据库: 加密已有数据
+	if saltText == "" {
+		aead, e = writeCipher(nil, secret)
+		if e != nil {
+			return fmt.Errorf("加密已有数据出错: %w", e)
+		}
+		return nil
+	}
+
+	salt, e := base64.StdEncoding.DecodeString(saltText)
+	if e != nil {
+		return e
+	}
+	a, e := deriveAEAD(secret, salt)
+	if e != nil {
+		return e
+	}
+
+	// 校验密钥
+	check, e := metaGet("cipher_check")
+	if e != nil {
+		return e
+	}
+	checkText, e := decryptText(a, check)
+	if e != nil || checkText != cipherCheckText {
+		return fmt.Errorf("数据库密钥错误")
+	}
+
+	aead = a
+	return nil
+}
+
+// Rekey 更换密钥(使用新秘密重新加密所有数据)
+func Rekey(secret []byte) error {
+	sm.Lock()
+	defer sm.Unlock()
+
+	a, e := writeCipher(aead, secret)
+	if e != nil {
+		return fmt.Errorf("更换密钥出错: %w", e)
+	}
+
+	aead = a
+	return nil
+}
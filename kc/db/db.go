@@ -3,6 +3,7 @@ package db
 import (
 	"fmt"
 	"os"
+	"sync"
 
 	"database/sql"
 	// 必须
@@ -11,22 +12,57 @@ import (
 
 // ChatMessageInfo 会话消息信息
 type ChatMessageInfo struct {
-	ID            int64  `json:"id"`
-	FromPeerID    string `json:"fromPeerID"`
-	ToPeerID      string `json:"toPeerID"`
-	Text          string `json:"text"`
-	FilePath      string `json:"file_path"`
-	FileName      string `json:"file_name"`
-	FileExtension string `json:"file_extension"`
-	FileSize      int64  `json:"file_size"`
-	State         string `json:"state"` // 发送/接收,进度,完成
-	Read          bool   `json:"read"`
+	ID            int64   `json:"id"`
+	FromPeerID    string  `json:"fromPeerID"`
+	ToPeerID      string  `json:"toPeerID"`
+	Text          string  `json:"text"`
+	FilePath      string  `json:"file_path"`
+	FileName      string  `json:"file_name"`
+	FileExtension string  `json:"file_extension"`
+	FileSize      int64   `json:"file_size"`
+	State         string  `json:"state"` // 见ChatMessageState开头的常量
+	Read          bool    `json:"read"`
+	Progress      float64 `json:"progress"` // 传输进度(0到1)
+	Error         string  `json:"error"`    // 失败原因
+	Type          string  `json:"type"`     // 见ChatMessageType开头的常量
+}
+
+// 会话消息状态
+const (
+	// 发送中
+	ChatMessageStateSend = "send"
+	// 接收中
+	ChatMessageStateReceive = "receive"
+	// 完成
+	ChatMessageStateDone = "done"
+	// 失败
+	ChatMessageStateFail = "fail"
+)
+
+// 会话消息类型
+const (
+	// 文本
+	ChatMessageTypeText = "text"
+	// 文件
+	ChatMessageTypeFile = "file"
+	// 联系人名片(文本为名片JSON)
+	ChatMessageTypeContact = "contact"
+)
+
+// ChatSetting 会话设置
+type ChatSetting struct {
+	PeerID           string `json:"peerID"`
+	RetentionDays    int64  `json:"retention_days"`    // 保留天数(仅本地), 0表示使用全局设置
+	DisappearSeconds int64  `json:"disappear_seconds"` // 阅后即焚秒数(双方一致), 0表示关闭
 }
 
 var db *sql.DB
 
-// Open 打开
-func Open(path string) error {
+// 加密读写锁, 更换密钥时阻止读写
+var sm sync.RWMutex
+
+// Open 打开(secret用于派生加密密钥)
+func Open(path string, secret []byte) error {
 	// 创建数据库
 	_, e := os.Stat(path)
 	if os.IsNotExist(e) {
@@ -56,14 +92,174 @@ func Open(path string) error {
 			"file_size"	INTEGER,
 			"state"	TEXT NOT NULL,
 			"read" BOOL NOT NULL,
+			"progress"	REAL NOT NULL DEFAULT 0,
+			"error"	TEXT NOT NULL DEFAULT '',
+			"type"	TEXT NOT NULL DEFAULT 'text',
 			PRIMARY KEY("id")
 		);
+		CREATE TABLE IF NOT EXISTS "chat_setting" (
+			"peerID"	TEXT NOT NULL UNIQUE,
+			"retention_days"	INTEGER NOT NULL,
+			"disappear_seconds"	INTEGER NOT NULL,
+			PRIMARY KEY("peerID")
+		);
+		CREATE TABLE IF NOT EXISTS "store" (
+			"bucket"	TEXT NOT NULL,
+			"key"	TEXT NOT NULL,
+			"value"	TEXT NOT NULL,
+			PRIMARY KEY("bucket","key")
+		);
+		CREATE TABLE IF NOT EXISTS "presence" (
+			"peerID"	TEXT NOT NULL,
+			"connect_time"	INTEGER NOT NULL,
+			"last_seen_time"	INTEGER NOT NULL,
+			"online"	BOOL NOT NULL
+		);
+		CREATE INDEX IF NOT EXISTS "presence_peerID" ON "presence" ("peerID", "connect_time");
+		CREATE TABLE IF NOT EXISTS "block_attempt" (
+			"peerID"	TEXT NOT NULL,
+			"time"	INTEGER NOT NULL,
+			"protocol"	TEXT NOT NULL
+		);
+		CREATE TABLE IF NOT EXISTS "peer_addr" (
+			"peerID"	TEXT NOT NULL,
+			"addr"	TEXT NOT NULL,
+			"seen_time"	INTEGER NOT NULL,
+			"success_time"	INTEGER NOT NULL,
+			"fail_count"	INTEGER NOT NULL,
+			PRIMARY KEY("peerID","addr")
+		);
+		CREATE TABLE IF NOT EXISTS "meta" (
+			"key"	TEXT NOT NULL UNIQUE,
+			"value"	TEXT,
+			PRIMARY KEY("key")
+		);
 	`)
 	if e != nil {
 		return e
 	}
 
-	return nil
+	// 迁移旧数据
+	e = migrateChatMessageState()
+	if e != nil {
+		return fmt.Errorf("迁移会话消息状态出错: %w", e)
+	}
+	e = migrateChatMessageType()
+	if e != nil {
+		return fmt.Errorf("迁移会话消息类型出错: %w", e)
+	}
+
+	// 初始化加密
+	sm.Lock()
+	defer sm.Unlock()
+	return openCipher(secret)
+}
+
+// 检查数据表是否有指定字段
+func tableHasColumn(table, column string) (bool, error) {
+	rows, e := db.Query(fmt.Sprintf(`pragma table_info(%s)`, table))
+	if e != nil {
+		return false, e
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var cid, notNull, pk int
+		var name, columnType string
+		var defaultValue sql.NullString
+		e = rows.Scan(&cid, &name, &columnType, &notNull, &defaultValue, &pk)
+		if e != nil {
+			return false, e
+		}
+		if name == column {
+			return true, nil
+		}
+	}
+	return false, rows.Err()
+}
+
+// 迁移会话消息状态: 添加进度和失败原因字段, 中文状态转为状态常量
+func migrateChatMessageState() error {
+	hasProgress, e := tableHasColumn("chat_message", "progress")
+	if e != nil {
+		return e
+	}
+	if hasProgress {
+		return nil
+	}
+
+	tx, e := db.Begin()
+	if e != nil {
+		return e
+	}
+	for _, sqlText := range []string{
+		`alter table chat_message add column "progress" REAL NOT NULL DEFAULT 0`,
+		`alter table chat_message add column "error" TEXT NOT NULL DEFAULT ''`,
+		fmt.Sprintf(`update chat_message set state = '%s' where state = '发送'`, ChatMessageStateSend),
+		fmt.Sprintf(`update chat_message set state = '%s' where state = '接收'`, ChatMessageStateReceive),
+		fmt.Sprintf(`update chat_message set state = '%s', progress = 1 where state = '完成'`, ChatMessageStateDone),
+		fmt.Sprintf(`update chat_message set state = '%s' where state like '失败%%'`, ChatMessageStateFail),
+	} {
+		_, e = tx.Exec(sqlText)
+		if e != nil {
+			tx.Rollback()
+			return e
+		}
+	}
+
+	return tx.Commit()
+}
+
+// 迁移会话消息类型: 添加类型字段, 有文件的消息为文件类型
+func migrateChatMessageType() error {
+	hasType, e := tableHasColumn("chat_message", "type")
+	if e != nil {
+		return e
+	}
+	if hasType {
+		return nil
+	}
+
+	tx, e := db.Begin()
+	if e != nil {
+		return e
+	}
+	for _, sqlText := range []string{
+		fmt.Sprintf(`alter table chat_message add column "type" TEXT NOT NULL DEFAULT '%s'`, ChatMessageTypeText),
+		fmt.Sprintf(`update chat_message set type = '%s' where file_size > 0`, ChatMessageTypeFile),
+	} {
+		_, e = tx.Exec(sqlText)
+		if e != nil {
+			tx.Rollback()
+			return e
+		}
+	}
+
+	return tx.Commit()
+}
+
+// 可读取的行(sql.Row或sql.Rows)
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// 从行中读取会话消息(解密加密字段)
+func scanChatMessageInfo(row rowScanner) (*ChatMessageInfo, error) {
+	var data ChatMessageInfo
+	e := row.Scan(&data.ID, &data.FromPeerID, &data.ToPeerID, &data.Text,
+		&data.FilePath, &data.FileName, &data.FileExtension, &data.FileSize,
+		&data.State, &data.Read, &data.Progress, &data.Error, &data.Type)
+	if e != nil {
+		return nil, e
+	}
+
+	for _, v := range []*string{&data.Text, &data.FilePath, &data.FileName} {
+		*v, e = decryptText(aead, *v)
+		if e != nil {
+			return nil, e
+		}
+	}
+
+	return &data, nil
 }
 
 // Close 关闭
@@ -73,8 +269,38 @@ func Close() {
 
 // ChatMessageInfoInsert 插入会话消息
 func ChatMessageInfoInsert(m *ChatMessageInfo) error {
-	_, e := db.Exec(fmt.Sprintf(`insert into chat_message values(%d, '%s', '%s', '%s', '%s', '%s', '%s', %d, '%s', %v)`,
-		m.ID, m.FromPeerID, m.ToPeerID, m.Text, m.FilePath, m.FileName, m.FileExtension, m.FileSize, m.State, m.Read))
+	sm.RLock()
+	defer sm.RUnlock()
+
+	values := []string{m.Text, m.FilePath, m.FileName}
+	for i, v := range values {
+		encryptValue, e := encryptText(aead, v)
+		if e != nil {
+			return e
+		}
+		values[i] = encryptValue
+	}
+
+	if m.Type == "" {
+		m.Type = ChatMessageTypeText
+		if m.FileSize > 0 {
+			m.Type = ChatMessageTypeFile
+		}
+	}
+
+	_, e := db.Exec(`insert into chat_message values(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		m.ID, m.FromPeerID, m.ToPeerID, values[0], values[1], values[2], m.FileExtension, m.FileSize, m.State, m.Read,
+		m.Progress, m.Error, m.Type)
+	if e != nil {
+		return e
+	}
+
+	return nil
+}
+
+// ChatMessageInfoUpdateState 更新会话消息状态, 进度和失败原因
+func ChatMessageInfoUpdateState(id int64, state string, progress float64, errorText string) error {
+	_, e := db.Exec(`update chat_message set state = ?, progress = ?, error = ? where id = ?`, state, progress, errorText, id)
 	if e != nil {
 		return e
 	}
@@ -82,9 +308,10 @@ func ChatMessageInfoInsert(m *ChatMessageInfo) error {
 	return nil
 }
 
-// ChatMessageInfoUpdateState 更新会话消息状态
-func ChatMessageInfoUpdateState(id int64, state string) error {
-	_, e := db.Exec(fmt.Sprintf(`update chat_message set state = '%s' where id = %d`, state, id))
+// ChatMessageInfoFailUnfinished 将没有完成的(发送中和接收中)会话消息设为失败(启动时调用, 上次运行中断的传输不会继续)
+func ChatMessageInfoFailUnfinished(errorText string) error {
+	_, e := db.Exec(`update chat_message set state = ?, error = ? where state in (?, ?)`,
+		ChatMessageStateFail, errorText, ChatMessageStateSend, ChatMessageStateReceive)
 	if e != nil {
 		return e
 	}
@@ -106,20 +333,21 @@ func ChatMessageInfoUpdateRead(peerID string, read bool) error {
 func ChatMessageInfoFind(peerID string) (*[]ChatMessageInfo, error) {
 	var dataArray []ChatMessageInfo
 
+	sm.RLock()
+	defer sm.RUnlock()
+
 	sqlText := fmt.Sprintf(`select * from chat_message where fromPeerID = '%s' or toPeerID = '%s'`, peerID, peerID)
 	rows, e := db.Query(sqlText)
 	if e != nil {
 		return nil, e
 	}
+	defer rows.Close()
 	for rows.Next() {
-		var data ChatMessageInfo
-		e = rows.Scan(&data.ID, &data.FromPeerID, &data.ToPeerID, &data.Text,
-			&data.FilePath, &data.FileName, &data.FileExtension, &data.FileSize,
-			&data.State, &data.Read)
+		data, e := scanChatMessageInfo(rows)
 		if e != nil {
 			return nil, e
 		}
-		dataArray = append(dataArray, data)
+		dataArray = append(dataArray, *data)
 	}
 
 	return &dataArray, nil
@@ -138,17 +366,94 @@ func ChatMessageInfoDeleteByPeerID(peerID string) error {
 
 // ChatMessageInfoGet 通过ID获取会话消息
 func ChatMessageInfoGet(id int64) (*ChatMessageInfo, error) {
-	var data ChatMessageInfo
+	sm.RLock()
+	defer sm.RUnlock()
 
 	sqlText := fmt.Sprintf(`select * from chat_message where id = %d`, id)
-	e := db.QueryRow(sqlText).Scan(&data.ID, &data.FromPeerID, &data.ToPeerID, &data.Text,
-		&data.FilePath, &data.FileName, &data.FileExtension, &data.FileSize,
-		&data.State, &data.Read)
-	if e == sql.ErrNoRows {
+	return scanChatMessageInfo(db.QueryRow(sqlText))
+}
+
+// ChatMessageInfoFindBefore 查询指定节点在ID(纳秒时间)之前的会话消息
+func ChatMessageInfoFindBefore(peerID string, id int64) (*[]ChatMessageInfo, error) {
+	var dataArray []ChatMessageInfo
+
+	sm.RLock()
+	defer sm.RUnlock()
+
+	rows, e := db.Query(`select * from chat_message where (fromPeerID = ? or toPeerID = ?) and cast(id as integer) < ?`, peerID, peerID, id)
+	if e != nil {
+		return nil, e
+	}
+	defer rows.Close()
+	for rows.Next() {
+		data, e := scanChatMessageInfo(rows)
+		if e != nil {
+			return nil, e
+		}
+		dataArray = append(dataArray, *data)
+	}
+
+	return &dataArray, nil
+}
+
+// ChatMessageInfoPeerIDs 查询有会话消息的节点ID(不含我的ID)
+func ChatMessageInfoPeerIDs(selfID string) ([]string, error) {
+	var array []string
+
+	rows, e := db.Query(`select fromPeerID from chat_message where fromPeerID != ? union select toPeerID from chat_message where toPeerID != ?`, selfID, selfID)
+	if e != nil {
 		return nil, e
 	}
+	defer rows.Close()
+	for rows.Next() {
+		var id string
+		e = rows.Scan(&id)
+		if e != nil {
+			return nil, e
+		}
+		array = append(array, id)
+	}
 
-	return &data, nil
+	return array, nil
+}
+
+// ChatMessageInfoFilePaths 查询所有会话消息引用的文件路径
+func ChatMessageInfoFilePaths() (map[string]bool, error) {
+	dm := make(map[string]bool)
+
+	sm.RLock()
+	defer sm.RUnlock()
+
+	rows, e := db.Query(`select file_path from chat_message where file_size > 0`)
+	if e != nil {
+		return nil, e
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var filePath string
+		e = rows.Scan(&filePath)
+		if e != nil {
+			return nil, e
+		}
+		filePath, e = decryptText(aead, filePath)
+		if e != nil {
+			return nil, e
+		}
+		dm[filePath] = true
+	}
+
+	return dm, nil
+}
+
+// ChatMessageInfoHas 会话消息是否存在
+func ChatMessageInfoHas(id int64) (bool, error) {
+	var c int64
+	e := db.QueryRow(`select count(*) from chat_message where id = ?`, id).Scan(&c)
+	if e != nil {
+		return false, e
+	}
+
+	return c > 0, nil
 }
 
 // ChatMessageInfoDeleteByID 通过消息ID删除会话消息
@@ -183,3 +488,34 @@ func ChatMessageInfoUnReadCount() (*map[string]int64, error) {
 
 	return &dm, nil
 }
+
+// ChatSettingGet 获取会话设置(没有设置时返回默认值)
+func ChatSettingGet(peerID string) (*ChatSetting, error) {
+	data := ChatSetting{PeerID: peerID}
+	e := db.QueryRow(`select * from chat_setting where peerID = ?`, peerID).Scan(&data.PeerID, &data.RetentionDays, &data.DisappearSeconds)
+	if e != nil && e != sql.ErrNoRows {
+		return nil, e
+	}
+
+	return &data, nil
+}
+
+// ChatSettingSet 保存会话设置
+func ChatSettingSet(data *ChatSetting) error {
+	_, e := db.Exec(`insert or replace into chat_setting values(?, ?, ?)`, data.PeerID, data.RetentionDays, data.DisappearSeconds)
+	if e != nil {
+		return e
+	}
+
+	return nil
+}
+
+// ChatSettingDelete 删除会话设置
+func ChatSettingDelete(peerID string) error {
+	_, e := db.Exec(`delete from chat_setting where peerID = ?`, peerID)
+	if e != nil {
+		return e
+	}
+
+	return nil
+}
@@ -0,0 +1,87 @@
+package db_test
+
+import (
+	"database/sql"
+	"path/filepath"
+	"testing"
+
+	kcdb "github.com/alx696/polong-core/kc/db"
+)
+
+func TestCipher(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "db.sqlite")
+
+	// 明文数据库
+	plainDB, e := sql.Open("sqlite3", path)
+	if e != nil {
+		t.Fatal(e)
+	}
+	_, e = plainDB.Exec(`
+		CREATE TABLE "chat_message" ("id" TEXT NOT NULL UNIQUE, "fromPeerID" TEXT NOT NULL, "toPeerID" TEXT NOT NULL,
+			"text" TEXT, "file_path" TEXT, "file_name" TEXT, "file_extension" TEXT, "file_size" INTEGER,
+			"state" TEXT NOT NULL, "read" BOOL NOT NULL, PRIMARY KEY("id"));
+		insert into chat_message values(1, 'a', 'b', '你好', '', '', '', 0, '完成', 1);
+	`)
+	plainDB.Close()
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	// 迁移
+	e = kcdb.Open(path, []byte("one"))
+	if e != nil {
+		t.Fatal(e)
+	}
+	m := kcdb.ChatMessageInfo{ID: 2, FromPeerID: "b", ToPeerID: "a", Text: "it's", FilePath: "/f/a.txt", FileName: "a.txt", FileSize: 1, State: kcdb.ChatMessageStateDone, Progress: 1}
+	e = kcdb.ChatMessageInfoInsert(&m)
+	if e != nil {
+		t.Fatal(e)
+	}
+	e = kcdb.StoreSet("option", "option", `{"name":"我"}`)
+	if e != nil {
+		t.Fatal(e)
+	}
+	e = kcdb.Rekey([]byte("two"))
+	if e != nil {
+		t.Fatal(e)
+	}
+	kcdb.Close()
+
+	// 数据库中没有明文
+	rawDB, _ := sql.Open("sqlite3", path)
+	var text string
+	e = rawDB.QueryRow(`select text from chat_message where id = 1`).Scan(&text)
+	rawDB.Close()
+	if e != nil || text == "你好" {
+		t.Fatal("没有加密", text, e)
+	}
+
+	// 旧密钥无法打开
+	if kcdb.Open(path, []byte("one")) == nil {
+		t.Fatal("旧密钥可以打开")
+	}
+	kcdb.Close()
+
+	e = kcdb.Open(path, []byte("two"))
+	if e != nil {
+		t.Fatal(e)
+	}
+	defer kcdb.Close()
+	array, e := kcdb.ChatMessageInfoFind("a")
+	if e != nil {
+		t.Fatal(e)
+	}
+	if len(*array) != 2 || (*array)[0].Text != "你好" || (*array)[1].FilePath != "/f/a.txt" {
+		t.Fatal("解密结果错误", *array)
+	}
+	if (*array)[0].State != kcdb.ChatMessageStateDone || (*array)[0].Progress != 1 {
+		t.Fatal("状态迁移错误", (*array)[0])
+	}
+	if (*array)[0].Type != kcdb.ChatMessageTypeText || (*array)[1].Type != kcdb.ChatMessageTypeFile {
+		t.Fatal("类型迁移错误", *array)
+	}
+	value, e := kcdb.StoreGet("option", "option")
+	if e != nil || value != `{"name":"我"}` {
+		t.Fatal("存储值错误", value, e)
+	}
+}
@@ -0,0 +1,104 @@
+package db
+
+const (
+	// 每个节点保留的地址数量
+	peerAddrKeepCount = 20
+	// 连续失败次数达到后可以清理
+	peerAddrMaxFail = 10
+	// 超过该时间(毫秒)没有成功的地址可以清理(7天)
+	peerAddrMaxAge = int64(7 * 24 * 60 * 60 * 1000)
+)
+
+// PeerAddr 节点地址
+type PeerAddr struct {
+	Addr        string `json:"addr"`         //多址
+	SeenTime    int64  `json:"seen_time"`    //最后获知时间(毫秒)
+	SuccessTime int64  `json:"success_time"` //最后连接成功时间(毫秒), 没有成功过时为0
+	FailCount   int64  `json:"fail_count"`   //连续失败次数
+}
+
+// PeerAddrSeen 记录获知的地址(识别协议交换的监听地址), 不改变成功时间和失败次数
+func PeerAddrSeen(peerID string, addrArray []string, t int64) error {
+	for _, addr := range addrArray {
+		_, e := db.Exec(`insert into peer_addr values(?, ?, ?, 0, 0) on conflict(peerID, addr) do update set seen_time = ?`,
+			peerID, addr, t, t)
+		if e != nil {
+			return e
+		}
+	}
+
+	return peerAddrTrim(peerID)
+}
+
+// PeerAddrSuccess 记录连接成功的地址(只清零这些地址的失败次数)
+func PeerAddrSuccess(peerID string, addrArray []string, t int64) error {
+	for _, addr := range addrArray {
+		_, e := db.Exec(`insert into peer_addr values(?, ?, ?, ?, 0) on conflict(peerID, addr) do update set seen_time = ?, success_time = ?, fail_count = 0`,
+			peerID, addr, t, t, t, t)
+		if e != nil {
+			return e
+		}
+	}
+
+	return peerAddrTrim(peerID)
+}
+
+// PeerAddrFail 记录连接失败的地址, 清理连续失败且长时间没有成功的地址
+func PeerAddrFail(peerID string, addrArray []string, t int64) error {
+	for _, addr := range addrArray {
+		_, e := db.Exec(`update peer_addr set fail_count = fail_count + 1 where peerID = ? and addr = ?`, peerID, addr)
+		if e != nil {
+			return e
+		}
+	}
+
+	_, e := db.Exec(`delete from peer_addr where peerID = ? and fail_count >= ? and success_time < ?`,
+		peerID, peerAddrMaxFail, t-peerAddrMaxAge)
+	if e != nil {
+		return e
+	}
+
+	return nil
+}
+
+// PeerAddrFind 查询节点的地址(最近成功的在前)
+func PeerAddrFind(peerID string) ([]PeerAddr, error) {
+	array := []PeerAddr{}
+
+	rows, e := db.Query(`select addr, seen_time, success_time, fail_count from peer_addr where peerID = ? order by success_time desc, seen_time desc`, peerID)
+	if e != nil {
+		return nil, e
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var data PeerAddr
+		e = rows.Scan(&data.Addr, &data.SeenTime, &data.SuccessTime, &data.FailCount)
+		if e != nil {
+			return nil, e
+		}
+		array = append(array, data)
+	}
+
+	return array, nil
+}
+
+// PeerAddrDeleteByPeerID 删除节点的地址
+func PeerAddrDeleteByPeerID(peerID string) error {
+	_, e := db.Exec(`delete from peer_addr where peerID = ?`, peerID)
+	if e != nil {
+		return e
+	}
+
+	return nil
+}
+
+// 清理多余的地址(保留最近成功和最近获知的)
+func peerAddrTrim(peerID string) error {
+	_, e := db.Exec(`delete from peer_addr where peerID = ? and rowid not in (select rowid from peer_addr where peerID = ? order by success_time desc, seen_time desc limit ?)`,
+		peerID, peerID, peerAddrKeepCount)
+	if e != nil {
+		return e
+	}
+
+	return nil
+}
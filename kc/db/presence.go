@@ -0,0 +1,109 @@
+package db
+
+import "database/sql"
+
+// 每个节点保留的在线记录数量
+const presenceKeepCount = 100
+
+// PresenceSession 在线记录(一次连接)
+type PresenceSession struct {
+	ConnectTime  int64 `json:"connect_time"`   //连接时间(毫秒)
+	LastSeenTime int64 `json:"last_seen_time"` //最后在线时间(毫秒), 断开时为断开时间
+	Online       bool  `json:"online"`         //是否仍在连接
+	Duration     int64 `json:"duration"`       //连接时长(毫秒)
+}
+
+// PresenceConnect 记录连接(已有未断开的记录时只更新最后在线时间)
+func PresenceConnect(peerID string, t int64) error {
+	var rowID int64
+	e := db.QueryRow(`select rowid from presence where peerID = ? and online = 1`, peerID).Scan(&rowID)
+	if e == nil {
+		_, e = db.Exec(`update presence set last_seen_time = ? where rowid = ?`, t, rowID)
+		return e
+	}
+	if e != sql.ErrNoRows {
+		return e
+	}
+
+	_, e = db.Exec(`insert into presence values(?, ?, ?, 1)`, peerID, t, t)
+	if e != nil {
+		return e
+	}
+
+	// 清理旧记录
+	_, e = db.Exec(`delete from presence where peerID = ? and rowid not in (select rowid from presence where peerID = ? order by connect_time desc limit ?)`,
+		peerID, peerID, presenceKeepCount)
+	if e != nil {
+		return e
+	}
+
+	return nil
+}
+
+// PresenceTouch 更新仍在连接的记录的最后在线时间
+func PresenceTouch(peerID string, t int64) error {
+	_, e := db.Exec(`update presence set last_seen_time = ? where peerID = ? and online = 1`, t, peerID)
+	if e != nil {
+		return e
+	}
+
+	return nil
+}
+
+// PresenceDisconnect 记录断开
+func PresenceDisconnect(peerID string, t int64) error {
+	_, e := db.Exec(`update presence set last_seen_time = ?, online = 0 where peerID = ? and online = 1`, t, peerID)
+	if e != nil {
+		return e
+	}
+
+	return nil
+}
+
+// PresenceCloseAll 结束所有未断开的记录(启动和停止时调用)
+// t大于0时作为断开时间, 否则保留最后在线时间(异常退出后启动时无法得知断开时间).
+func PresenceCloseAll(t int64) error {
+	var e error
+	if t > 0 {
+		_, e = db.Exec(`update presence set last_seen_time = ?, online = 0 where online = 1`, t)
+	} else {
+		_, e = db.Exec(`update presence set online = 0 where online = 1`)
+	}
+	if e != nil {
+		return e
+	}
+
+	return nil
+}
+
+// PresenceFind 查询最近的在线记录(按连接时间倒序)
+func PresenceFind(peerID string, limit int) ([]PresenceSession, error) {
+	array := []PresenceSession{}
+
+	rows, e := db.Query(`select connect_time, last_seen_time, online from presence where peerID = ? order by connect_time desc limit ?`, peerID, limit)
+	if e != nil {
+		return nil, e
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var data PresenceSession
+		e = rows.Scan(&data.ConnectTime, &data.LastSeenTime, &data.Online)
+		if e != nil {
+			return nil, e
+		}
+		data.Duration = data.LastSeenTime - data.ConnectTime
+		array = append(array, data)
+	}
+
+	return array, nil
+}
+
+// PresenceDeleteByPeerID 删除节点的在线记录
+func PresenceDeleteByPeerID(peerID string) error {
+	_, e := db.Exec(`delete from presence where peerID = ?`, peerID)
+	if e != nil {
+		return e
+	}
+
+	return nil
+}
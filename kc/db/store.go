@@ -0,0 +1,125 @@
+package db
+
+import (
+	"crypto/cipher"
+	"database/sql"
+	"fmt"
+)
+
+// StoreGet 获取存储值(没有时返回空字符串)
+func StoreGet(bucket, key string) (string, error) {
+	sm.RLock()
+	defer sm.RUnlock()
+
+	var value string
+	e := db.QueryRow(`select value from store where bucket = ? and key = ?`, bucket, key).Scan(&value)
+	if e == sql.ErrNoRows {
+		return "", nil
+	}
+	if e != nil {
+		return "", e
+	}
+
+	return decryptText(aead, value)
+}
+
+// StoreFind 获取分组中所有存储值
+func StoreFind(bucket string) (map[string]string, error) {
+	dm := make(map[string]string)
+
+	sm.RLock()
+	defer sm.RUnlock()
+
+	rows, e := db.Query(`select key, value from store where bucket = ?`, bucket)
+	if e != nil {
+		return nil, e
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var key, value string
+		e = rows.Scan(&key, &value)
+		if e != nil {
+			return nil, e
+		}
+		dm[key], e = decryptText(aead, value)
+		if e != nil {
+			return nil, e
+		}
+	}
+
+	return dm, nil
+}
+
+// StoreSet 保存存储值
+func StoreSet(bucket, key, value string) error {
+	return StoreSetMap(bucket, map[string]string{key: value})
+}
+
+// StoreSetMap 在一个事务中保存多个存储值
+func StoreSetMap(bucket string, dm map[string]string) error {
+	sm.RLock()
+	defer sm.RUnlock()
+
+	tx, e := db.Begin()
+	if e != nil {
+		return e
+	}
+	for key, value := range dm {
+		value, e = encryptText(aead, value)
+		if e == nil {
+			_, e = tx.Exec(`insert or replace into store values(?, ?, ?)`, bucket, key, value)
+		}
+		if e != nil {
+			tx.Rollback()
+			return e
+		}
+	}
+
+	return tx.Commit()
+}
+
+// StoreDelete 删除存储值
+func StoreDelete(bucket, key string) error {
+	_, e := db.Exec(`delete from store where bucket = ? and key = ?`, bucket, key)
+	if e != nil {
+		return e
+	}
+
+	return nil
+}
+
+// 使用新的加密器重新加密所有存储值(旧加密器为空时说明原来是明文)
+func reencryptStore(tx *sql.Tx, oldAEAD, newAEAD cipher.AEAD) error {
+	type row struct {
+		bucket, key, value string
+	}
+
+	rows, e := tx.Query(`select bucket, key, value from store`)
+	if e != nil {
+		return e
+	}
+	var array []row
+	for rows.Next() {
+		var r row
+		e = rows.Scan(&r.bucket, &r.key, &r.value)
+		if e != nil {
+			rows.Close()
+			return e
+		}
+		array = append(array, r)
+	}
+	rows.Close()
+
+	for _, r := range array {
+		value, e := recryptText(oldAEAD, newAEAD, r.value)
+		if e != nil {
+			return fmt.Errorf("存储值%s/%s: %w", r.bucket, r.key, e)
+		}
+		_, e = tx.Exec(`update store set value = ? where bucket = ? and key = ?`, value, r.bucket, r.key)
+		if e != nil {
+			return e
+		}
+	}
+
+	return nil
+}
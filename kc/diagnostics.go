@@ -0,0 +1,196 @@
+package kc
+
+import (
+	"encoding/json"
+	"log"
+	"sync"
+
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p-core/event"
+	"github.com/libp2p/go-libp2p-core/network"
+	basichost "github.com/libp2p/go-libp2p/p2p/host/basic"
+	"github.com/multiformats/go-multiaddr"
+)
+
+// NetworkDiagnostics 网络诊断
+type NetworkDiagnostics struct {
+	ID string `json:"id"`
+	// 是否在私有网络中
+	PrivateNetwork bool `json:"private_network"`
+	// 公网可达性(自动NAT检测): unknown, public, private
+	Reachability string `json:"reachability"`
+	// NAT设备类型(按传输协议): unknown, cone, symmetric
+	NATDeviceTypeTCP string `json:"nat_device_type_tcp"`
+	NATDeviceTypeUDP string `json:"nat_device_type_udp"`
+	// 监听地址(网卡地址)
+	ListenAddrArray []string `json:"listen_addr_array"`
+	// 观察地址(其它节点看到的地址, 包括端口映射地址)
+	ObservedAddrArray []string `json:"observed_addr_array"`
+	// 是否找到UPnP(NAT-PMP)设备
+	NATDeviceFound bool `json:"nat_device_found"`
+	// 端口映射
+	NATMappingArray []NATMapping `json:"nat_mapping_array"`
+	// 中继地址(自动中继预留)
+	RelayAddrArray []string `json:"relay_addr_array"`
+	// DHT路由表节点数量
+	RoutingTableSize int `json:"routing_table_size"`
+	// 节点数量
+	PeerCount int `json:"peer_count"`
+	// 连接数量
+	ConnCount int `json:"conn_count"`
+	// 引导地址状态
+	BootstrapArray []BootstrapState `json:"bootstrap_array"`
+}
+
+// NATMapping 端口映射
+type NATMapping struct {
+	// tcp或udp
+	Protocol     string `json:"protocol"`
+	InternalPort int    `json:"internal_port"`
+	// 没有映射成功时为0
+	ExternalPort int `json:"external_port"`
+	// 没有映射成功时为空
+	ExternalAddr string `json:"external_addr"`
+}
+
+// 端口映射管理器(用于获取映射状态)
+var natManager basichost.NATManager
+
+var networkEventLock sync.Mutex
+
+// 公网可达性
+var reachability = network.ReachabilityUnknown
+
+// NAT设备类型
+var natDeviceTypeMap = make(map[network.NATTransportProtocol]network.NATDeviceType)
+
+// 创建端口映射(UPnP)的libp2p选项, 保留管理器用于诊断
+func natPortMapOption() libp2p.Option {
+	return libp2p.NATManager(func(n network.Network) basichost.NATManager {
+		natManager = basichost.NewNATManager(n)
+		return natManager
+	})
+}
+
+// 订阅网络事件(公网可达性, NAT设备类型), 主机停止时结束
+func watchNetworkEvent() error {
+	sub, e := h.EventBus().Subscribe([]interface{}{
+		new(event.EvtLocalReachabilityChanged),
+		new(event.EvtNATDeviceTypeChanged),
+	})
+	if e != nil {
+		return e
+	}
+
+	go func() {
+		defer sub.Close()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case evt, ok := <-sub.Out():
+				if !ok {
+					return
+				}
+
+				networkEventLock.Lock()
+				switch v := evt.(type) {
+				case event.EvtLocalReachabilityChanged:
+					reachability = v.Reachability
+					log.Println("公网可达性变化", reachabilityText(v.Reachability))
+				case event.EvtNATDeviceTypeChanged:
+					natDeviceTypeMap[v.TransportProtocol] = v.NatDeviceType
+				}
+				networkEventLock.Unlock()
+			}
+		}
+	}()
+	return nil
+}
+
+// 公网可达性文本
+func reachabilityText(r network.Reachability) string {
+	switch r {
+	case network.ReachabilityPublic:
+		return "public"
+	case network.ReachabilityPrivate:
+		return "private"
+	default:
+		return "unknown"
+	}
+}
+
+// NAT设备类型文本
+func natDeviceTypeText(t network.NATDeviceType) string {
+	switch t {
+	case network.NATDeviceTypeCone:
+		return "cone"
+	case network.NATDeviceTypeSymmetric:
+		return "symmetric"
+	default:
+		return "unknown"
+	}
+}
+
+// GetNetworkDiagnostics 获取网络诊断, 返回NetworkDiagnostics
+func GetNetworkDiagnostics() string {
+	diagnostics := NetworkDiagnostics{
+		ID:                h.ID().Pretty(),
+		PrivateNetwork:    privateNetwork,
+		ListenAddrArray:   []string{},
+		ObservedAddrArray: []string{},
+		NATMappingArray:   []NATMapping{},
+		RelayAddrArray:    []string{},
+		RoutingTableSize:  idht.RoutingTable().Size(),
+		PeerCount:         h.Peerstore().Peers().Len(),
+		ConnCount:         len(h.Network().Conns()),
+		BootstrapArray:    bootstrapStateArray(),
+	}
+
+	networkEventLock.Lock()
+	diagnostics.Reachability = reachabilityText(reachability)
+	diagnostics.NATDeviceTypeTCP = natDeviceTypeText(natDeviceTypeMap[network.NATTransportTCP])
+	diagnostics.NATDeviceTypeUDP = natDeviceTypeText(natDeviceTypeMap[network.NATTransportUDP])
+	networkEventLock.Unlock()
+
+	// 监听地址
+	interfaceAddrArray, e := h.Network().InterfaceListenAddresses()
+	if e != nil {
+		log.Println("获取监听地址出错", e)
+	}
+	for _, v := range interfaceAddrArray {
+		diagnostics.ListenAddrArray = append(diagnostics.ListenAddrArray, v.String())
+	}
+
+	// 公布的地址中区分中继地址和观察地址
+	for _, v := range h.Addrs() {
+		if _, e := v.ValueForProtocol(multiaddr.P_CIRCUIT); e == nil {
+			diagnostics.RelayAddrArray = append(diagnostics.RelayAddrArray, v.String())
+			continue
+		}
+		if !valueInArray(v.String(), diagnostics.ListenAddrArray) {
+			diagnostics.ObservedAddrArray = append(diagnostics.ObservedAddrArray, v.String())
+		}
+	}
+
+	// 端口映射
+	if natManager != nil {
+		if nat := natManager.NAT(); nat != nil {
+			diagnostics.NATDeviceFound = true
+			for _, m := range nat.Mappings() {
+				mapping := NATMapping{
+					Protocol:     m.Protocol(),
+					InternalPort: m.InternalPort(),
+					ExternalPort: m.ExternalPort(),
+				}
+				if addr, e := m.ExternalAddr(); e == nil {
+					mapping.ExternalAddr = addr.String()
+				}
+				diagnostics.NATMappingArray = append(diagnostics.NATMappingArray, mapping)
+			}
+		}
+	}
+
+	jsonBytes, _ := json.Marshal(diagnostics)
+	return string(jsonBytes)
+}
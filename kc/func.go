@@ -5,14 +5,18 @@ import (
 	"bytes"
 	"context"
 	"encoding/base64"
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"log"
 	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"time"
 
+	kccontact "github.com/alx696/polong-core/kc/contact"
+	kcdb "github.com/alx696/polong-core/kc/db"
 	"github.com/libp2p/go-libp2p-core/crypto"
 	"github.com/libp2p/go-libp2p-core/network"
 	"github.com/libp2p/go-libp2p-core/peer"
@@ -32,6 +36,31 @@ func valueInArray(value string, array []string) bool {
 	return false
 }
 
+// 从数组中移除值(返回新数组)
+func removeValueFromArray(value string, array []string) []string {
+	var newArray []string
+	for _, v := range array {
+		if v != value {
+			newArray = append(newArray, v)
+		}
+	}
+
+	return newArray
+}
+
+// 修改联系人并执行订阅回调
+func updateContact(id string, fn func(data *kccontact.Contact)) error {
+	data, e := kccontact.Update(id, fn)
+	if e != nil {
+		return e
+	}
+
+	// 执行订阅回调
+	jsonBytes, _ := json.Marshal(*data)
+	feedCallback.FeedCallbackOnContactUpdate(string(jsonBytes))
+	return nil
+}
+
 // 获取密钥(没有时自动生成)
 func getPrivateKey(privateKeyPath string) (*crypto.PrivKey, error) {
 	var privateKey crypto.PrivKey
@@ -66,6 +95,89 @@ func getPrivateKey(privateKeyPath string) (*crypto.PrivKey, error) {
 	return &privateKey, nil
 }
 
+// 获取目录中不重复的文件路径(文件存在时重新命名)
+func uniqueFilePath(directory, fileName string) string {
+	filePath := filepath.Join(directory, fileName)
+	_, e := os.Stat(filePath)
+	if e == nil {
+		fileExt := filepath.Ext(fileName) // 后缀带点
+		fileBaseName := strings.Replace(fileName, fileExt, "", 1)
+		filePath = filepath.Join(directory, fmt.Sprintf("%s[%d]%s", fileBaseName, time.Now().Nanosecond(), fileExt))
+	}
+	return filePath
+}
+
+// 路径是否在目录中(不包括目录本身)
+func pathInDirectory(directory, path string) bool {
+	relativePath, e := filepath.Rel(directory, path)
+	if e != nil || relativePath == "." || relativePath == ".." || strings.HasPrefix(relativePath, ".."+string(filepath.Separator)) {
+		return false
+	}
+	return true
+}
+
+// 删除会话消息文件
+// 注意: 只删除文件目录中的文件, 发送时引用的外部文件不会删除.
+func removeChatMessageFile(m *kcdb.ChatMessageInfo) {
+	if m.FileSize == 0 || m.FilePath == "" {
+		return
+	}
+
+	if !pathInDirectory(fileDirectory, m.FilePath) {
+		return
+	}
+
+	e = os.Remove(m.FilePath)
+	if e != nil && !os.IsNotExist(e) {
+		log.Println("删除会话消息文件出错", m.FilePath, e)
+	}
+}
+
+// 查找文件目录中没有被会话消息引用的文件(remove为真时删除), 跳过最近修改的文件
+func collectOrphanFiles(remove bool, minAge time.Duration) ([]string, error) {
+	filePaths, e := kcdb.ChatMessageInfoFilePaths()
+	if e != nil {
+		return nil, e
+	}
+
+	infos, e := ioutil.ReadDir(fileDirectory)
+	if e != nil {
+		return nil, e
+	}
+
+	var array []string
+	for _, info := range infos {
+		if !info.Mode().IsRegular() || time.Since(info.ModTime()) < minAge {
+			continue
+		}
+
+		filePath := filepath.Join(fileDirectory, info.Name())
+		if filePaths[filePath] {
+			continue
+		}
+
+		if remove {
+			e = os.Remove(filePath)
+			if e != nil {
+				log.Println("删除无引用文件出错", filePath, e)
+				continue
+			}
+		}
+		array = append(array, filePath)
+	}
+
+	return array, nil
+}
+
+// 获取数据库加密秘密(设置了口令时使用口令, 否则使用节点密钥)
+func databaseSecret(passphrase string, privateKey crypto.PrivKey) ([]byte, error) {
+	if passphrase != "" {
+		return []byte(passphrase), nil
+	}
+
+	return crypto.MarshalPrivateKey(privateKey)
+}
+
 // 清除节点网络缓存
 // 防止拨号器使用无法连接地址快速重拨导致一直连不上.
 // https://github.com/prysmaticlabs/prysm/issues/2674#issuecomment-529229685
@@ -138,19 +250,22 @@ func findDHTAddrInfo(id peer.ID) (*peer.AddrInfo, error) {
 }
 
 // 连接DHT节点(用于保持连接状态)
+// 先使用保存的地址, 失败后再通过DHT查找.
 func connectDHTPeer(id peer.ID) error {
-	addrInfo, e := findDHTAddrInfo(id)
-	if e != nil {
-		clearPeerNetworkCache(id)
-		return e
-	}
+	if connectStoredPeer(id) != nil {
+		addrInfo, e := findDHTAddrInfo(id)
+		if e != nil {
+			clearPeerNetworkCache(id)
+			return e
+		}
 
-	localContext, localContextCancel := context.WithTimeout(ctx, time.Second*1)
-	defer localContextCancel()
-	e = h.Connect(localContext, *addrInfo)
-	if e != nil {
-		clearPeerNetworkCache(id)
-		return e
+		localContext, localContextCancel := context.WithTimeout(ctx, time.Second*1)
+		defer localContextCancel()
+		e = h.Connect(localContext, *addrInfo)
+		if e != nil {
+			clearPeerNetworkCache(id)
+			return e
+		}
 	}
 
 	// 标记并保护指定节点, 防止连接被清理
@@ -0,0 +1,104 @@
+package kc
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	kcblock "github.com/alx696/polong-core/kc/block"
+	kcdb "github.com/alx696/polong-core/kc/db"
+	"github.com/libp2p/go-libp2p-core/control"
+	"github.com/libp2p/go-libp2p-core/network"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/multiformats/go-multiaddr"
+)
+
+// 连接层拒绝记录的协议名称
+const blockAttemptProtocolConnection = "connection"
+
+// 同一节点记录拒绝记录的最小间隔(防止重复连接写满拒绝记录)
+const blockAttemptInterval = time.Minute
+
+var blockAttemptLock sync.Mutex
+
+// 节点最后记录拒绝记录的时间
+var blockAttemptTimeMap = make(map[string]time.Time)
+
+// 记录拒绝记录(同一节点限制频率), 返回是否记录
+func recordBlockAttempt(id, protocol string) bool {
+	now := time.Now()
+	blockAttemptLock.Lock()
+	if now.Sub(blockAttemptTimeMap[id]) < blockAttemptInterval {
+		blockAttemptLock.Unlock()
+		return false
+	}
+	blockAttemptTimeMap[id] = now
+	blockAttemptLock.Unlock()
+
+	e := kcdb.BlockAttemptInsert(&kcdb.BlockAttempt{PeerID: id, Time: now.UnixNano() / 1e6, Protocol: protocol})
+	if e != nil {
+		log.Println("保存拒绝记录出错", e)
+	}
+	return true
+}
+
+// 连接拦截器: 在拨号, 接受和安全握手时拒绝拒绝名单中的节点
+// 对方主动连接时记录拒绝记录(流处理不会再收到拒绝名单中的节点).
+type blockGater struct{}
+
+// 是否拒绝节点
+func (g *blockGater) blocked(p peer.ID) bool {
+	return kcblock.Has(p.Pretty(), time.Now().UnixNano()/1e6)
+}
+
+// InterceptPeerDial 拨号前检查节点
+func (g *blockGater) InterceptPeerDial(p peer.ID) bool {
+	return !g.blocked(p)
+}
+
+// InterceptAddrDial 拨号地址前检查节点
+func (g *blockGater) InterceptAddrDial(p peer.ID, _ multiaddr.Multiaddr) bool {
+	return !g.blocked(p)
+}
+
+// InterceptAccept 接受连接时还不知道节点, 全部允许
+func (g *blockGater) InterceptAccept(_ network.ConnMultiaddrs) bool {
+	return true
+}
+
+// InterceptSecured 安全握手后检查节点
+func (g *blockGater) InterceptSecured(direction network.Direction, p peer.ID, _ network.ConnMultiaddrs) bool {
+	if !g.blocked(p) {
+		return true
+	}
+
+	// 记录对方的连接(限制频率, 也只在记录时输出日志)
+	if direction == network.DirInbound {
+		go func() {
+			if recordBlockAttempt(p.Pretty(), blockAttemptProtocolConnection) {
+				log.Println("拒绝连接:", p)
+			}
+		}()
+	}
+	return false
+}
+
+// InterceptUpgraded 升级后不再检查
+func (g *blockGater) InterceptUpgraded(_ network.Conn) (bool, control.DisconnectReason) {
+	return true, 0
+}
+
+// 断开和节点的现有连接(加入拒绝名单时调用)
+func closePeer(id string) {
+	peerID, e := peer.Decode(id)
+	if e != nil {
+		return
+	}
+	e = h.Network().ClosePeer(peerID)
+	if e != nil {
+		log.Println("断开节点连接出错", id, e)
+	}
+
+	// 连接状态检查和网络通知会跳过拒绝名单中的节点, 在此更新状态
+	connStateUpdate(peerID)
+}
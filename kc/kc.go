@@ -4,15 +4,16 @@ import (
 	"bufio"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"os"
 	"path/filepath"
 	"strconv"
-	"strings"
 	"time"
 
+	kcblock "github.com/alx696/polong-core/kc/block"
 	kccontact "github.com/alx696/polong-core/kc/contact"
 	kcdb "github.com/alx696/polong-core/kc/db"
 	kcoption "github.com/alx696/polong-core/kc/option"
@@ -24,7 +25,6 @@ import (
 	"github.com/libp2p/go-libp2p-core/peer"
 	routing "github.com/libp2p/go-libp2p-core/routing"
 	dht "github.com/libp2p/go-libp2p-kad-dht"
-	libp2pquic "github.com/libp2p/go-libp2p-quic-transport"
 	libp2ptls "github.com/libp2p/go-libp2p-tls"
 	"github.com/libp2p/go-libp2p/p2p/discovery"
 )
@@ -36,6 +36,12 @@ const (
 	protocolIDMessageText = "/lilu.red/kc/1/message/text"
 	// 协议ID：文件消息
 	protocolIDMessageFile = "/lilu.red/kc/1/message/file"
+	// 协议ID：会话设置消息
+	protocolIDMessageSetting = "/lilu.red/kc/1/message/setting"
+	// 协议ID：名片消息
+	protocolIDMessageContact = "/lilu.red/kc/1/message/contact"
+	// 协议ID：头像
+	protocolIDAvatar = "/lilu.red/kc/1/avatar"
 	// 协议ID：远程控制消息
 	protocolIDRemoteControlMessage = "/github.com/alx696/polong/remote_control/message"
 	// 协议ID：远程控制视频
@@ -44,22 +50,35 @@ const (
 	mDNSServiceTag = "/lilu.red/kc/mdns"
 	// 连接保护标记:保持,权重100.
 	connProtectTagKeep = "keep"
+	// 交换信息回复:等待对方同意
+	resultWaitingApproval = "等待同意"
 )
 
+// 交换信息时对方需要同意
+var errWaitingApproval = errors.New("等待对方同意")
+
 // FeedCallback 订阅回调
 type FeedCallback interface {
 	// 联系人更新(新增)
 	FeedCallbackOnContactUpdate(json string)
 	// 联系人删除
 	FeedCallbackOnContactDelete(id string)
+	// 收到联系请求(Request的JSON)
+	FeedCallbackOnContactRequest(json string)
 
 	// 节点连接状态变化
 	FeedCallbackOnPeerConnectState(id string, isConnect bool)
+	// 联系人连接详情(定时推送PeerConnection数组的JSON)
+	FeedCallbackOnPeerConnection(json string)
 
 	// 会话消息
 	FeedCallbackOnChatMessage(peerID string, chatMessage string)
-	// 会话消息状态
+	// 会话消息状态(ChatMessageState的JSON)
 	FeedCallbackOnChatMessageState(peerID string, messageID int64, state string)
+	// 会话消息删除(过期清理)
+	FeedCallbackOnChatMessageDelete(peerID string, messageID int64)
+	// 会话设置更新(对方修改阅后即焚)
+	FeedCallbackOnChatSettingUpdate(peerID string, setting string)
 
 	// 远程控制收到请求
 	FeedCallbackOnRemoteControlRequest(peerID string)
@@ -89,6 +108,16 @@ type FileInfo struct {
 	Size int64 `json:"size"`
 }
 
+// ChatMessageState 会话消息状态(推送)
+type ChatMessageState struct {
+	// 状态, 见kcdb.ChatMessageState开头的常量
+	State string `json:"state"`
+	// 传输进度(0到1)
+	Progress float64 `json:"progress"`
+	// 失败原因
+	Error string `json:"error"`
+}
+
 var e error
 var ctx context.Context
 var ctxCancel context.CancelFunc
@@ -98,6 +127,7 @@ var fileDirectory string         // 文件目录
 var feedCallback FeedCallback    // 实时推送回调
 var ready bool                   // 节点是否就绪标记
 var stopChan = make(chan int, 1) //节点是否停止标记
+var databasePassphrase string    // 数据库口令(为空时使用节点密钥派生)
 
 // 处理文件消息
 func messageFileStreamHandler(s network.Stream) {
@@ -108,10 +138,10 @@ func messageFileStreamHandler(s network.Stream) {
 	// 创建读写器
 	rw := bufio.NewReadWriter(bufio.NewReader(s), bufio.NewWriter(s))
 
-	// 检查拒绝名单
-	if valueInArray(remotePeerID.Pretty(), kcoption.Get().BlacklistIDArray) {
-		log.Println("已在拒绝名单中的远程节点:", remotePeerID)
-		resultBytes := []byte("拒绝")
+	// 检查访问权限
+	if result := checkPermission(s, false); result != "" {
+		log.Println("拒绝远程节点:", remotePeerID, result)
+		resultBytes := []byte(result)
 		writeTextToReadWriter(rw, &resultBytes)
 		return
 	}
@@ -133,19 +163,12 @@ func messageFileStreamHandler(s network.Stream) {
 	}
 	log.Println("收到文件信息内容:", fileInfo)
 
-	// 准备文件路径
+	// 准备文件路径(文件如果存在则重新命名)
 	fileName := fileInfo.Name
-	filePath := filepath.Join(fileDirectory, fileName)
-	// 文件如果存在则重新命名
-	_, e = os.Stat(filePath)
-	if e == nil {
-		fileExt := filepath.Ext(fileName) // 后缀带点
-		fileBaseName := strings.Replace(fileName, fileExt, "", 1)
-		filePath = filepath.Join(fileDirectory, fmt.Sprintf("%s[%d]%s", fileBaseName, time.Now().Nanosecond(), fileExt))
-	}
+	filePath := uniqueFilePath(fileDirectory, fileName)
 
 	// 保存消息
-	m := kcdb.ChatMessageInfo{ID: time.Now().UnixNano(), FromPeerID: remotePeerID.Pretty(), ToPeerID: h.ID().Pretty(), Text: "", FilePath: filePath, FileName: fileName, FileExtension: fileInfo.Extension, FileSize: fileInfo.Size, State: "接收", Read: false}
+	m := kcdb.ChatMessageInfo{ID: time.Now().UnixNano(), FromPeerID: remotePeerID.Pretty(), ToPeerID: h.ID().Pretty(), Text: "", FilePath: filePath, FileName: fileName, FileExtension: fileInfo.Extension, FileSize: fileInfo.Size, Type: kcdb.ChatMessageTypeFile, State: kcdb.ChatMessageStateReceive, Read: false}
 	e = kcdb.ChatMessageInfoInsert(&m)
 	if e != nil {
 		log.Println("保存消息时出错", e)
@@ -169,10 +192,7 @@ func messageFileStreamHandler(s network.Stream) {
 				log.Println("消息文件接收：读取文件时没有更多数据")
 			} else {
 				log.Println("消息文件接收出错", e)
-				// 保存入库
-				kcdb.ChatMessageInfoUpdateState(m.ID, "失败")
-				// 订阅回调
-				feedCallback.FeedCallbackOnChatMessageState(m.FromPeerID, m.ID, fmt.Sprintf(`失败: %s`, e.Error()))
+				updateChatMessageState(m.FromPeerID, m.ID, ChatMessageState{State: kcdb.ChatMessageStateFail, Error: e.Error()}, true)
 				return
 			}
 		}
@@ -182,10 +202,7 @@ func messageFileStreamHandler(s network.Stream) {
 			wn, e = f.Write(buf[0:rn])
 			if e != nil {
 				log.Println("消息文件接收出错", e)
-				// 保存入库
-				kcdb.ChatMessageInfoUpdateState(m.ID, "失败")
-				// 订阅回调
-				feedCallback.FeedCallbackOnChatMessageState(m.FromPeerID, m.ID, fmt.Sprintf(`失败: %s`, e.Error()))
+				updateChatMessageState(m.FromPeerID, m.ID, ChatMessageState{State: kcdb.ChatMessageStateFail, Error: e.Error()}, true)
 				return
 			}
 		}
@@ -200,16 +217,12 @@ func messageFileStreamHandler(s network.Stream) {
 		if doneSum == fileInfo.Size {
 			log.Println("消息文件接收完毕")
 
-			// 保存入库
-			kcdb.ChatMessageInfoUpdateState(m.ID, "完成")
-			// 订阅回调
-			feedCallback.FeedCallbackOnChatMessageState(m.FromPeerID, m.ID, "完成")
+			updateChatMessageState(m.FromPeerID, m.ID, ChatMessageState{State: kcdb.ChatMessageStateDone, Progress: 1}, true)
 
 			return
 		}
 
-		// 订阅回调
-		feedCallback.FeedCallbackOnChatMessageState(m.FromPeerID, m.ID, fmt.Sprintf("接收 %.0f%s", percentage*100, "%"))
+		updateChatMessageState(m.FromPeerID, m.ID, ChatMessageState{State: kcdb.ChatMessageStateReceive, Progress: percentage}, false)
 	}
 }
 
@@ -230,8 +243,8 @@ func sendMessageFile(id string, fileInfo FileInfo, onProgress func(float64)) err
 		return e
 	}
 	result := string(*resultBytes)
-	if result == "拒绝" {
-		return fmt.Errorf("对方拒绝")
+	if e := refuseError(result); e != nil {
+		return e
 	}
 
 	// 写入文件信息
@@ -296,10 +309,10 @@ func messageTextStreamHandler(s network.Stream) {
 	// 创建读写器
 	rw := bufio.NewReadWriter(bufio.NewReader(s), bufio.NewWriter(s))
 
-	// 检查拒绝名单
-	if valueInArray(remotePeerID.Pretty(), kcoption.Get().BlacklistIDArray) {
-		log.Println("已在拒绝名单中的远程节点:", remotePeerID)
-		resultBytes := []byte("拒绝")
+	// 检查访问权限
+	if result := checkPermission(s, false); result != "" {
+		log.Println("拒绝远程节点:", remotePeerID, result)
+		resultBytes := []byte(result)
 		writeTextToReadWriter(rw, &resultBytes)
 		return
 	}
@@ -321,7 +334,7 @@ func messageTextStreamHandler(s network.Stream) {
 	}
 
 	// 保存消息
-	chatMessageInfo := kcdb.ChatMessageInfo{ID: time.Now().UnixNano(), FromPeerID: remotePeerID.Pretty(), ToPeerID: h.ID().Pretty(), Text: text, State: "完成", Read: false}
+	chatMessageInfo := kcdb.ChatMessageInfo{ID: time.Now().UnixNano(), FromPeerID: remotePeerID.Pretty(), ToPeerID: h.ID().Pretty(), Text: text, Type: kcdb.ChatMessageTypeText, State: kcdb.ChatMessageStateDone, Progress: 1, Read: false}
 	e = kcdb.ChatMessageInfoInsert(&chatMessageInfo)
 	if e != nil {
 		log.Println("保存消息时出错", e)
@@ -359,8 +372,8 @@ func sendMessageText(id, text string) error {
 	result := string(*resultBytes)
 
 	// 检查异常状态
-	if result == "拒绝" {
-		return fmt.Errorf("拒绝")
+	if e := refuseError(result); e != nil {
+		return e
 	}
 
 	return nil
@@ -382,9 +395,10 @@ func sendChatMessage(m *kcdb.ChatMessageInfo) {
 	feedCallback.FeedCallbackOnChatMessage(m.FromPeerID, string(jsonBytes))
 
 	var se error
-	if m.FileSize == 0 {
-		se = sendMessageText(m.ToPeerID, m.Text)
-	} else {
+	switch m.Type {
+	case kcdb.ChatMessageTypeContact:
+		se = sendMessageContact(m.ToPeerID, m.Text)
+	case kcdb.ChatMessageTypeFile:
 		se = sendMessageFile(m.ToPeerID,
 			FileInfo{
 				Path:      m.FilePath,
@@ -393,24 +407,33 @@ func sendChatMessage(m *kcdb.ChatMessageInfo) {
 				Size:      m.FileSize,
 			},
 			func(p float64) {
-				// 订阅回调
-				feedCallback.FeedCallbackOnChatMessageState(m.FromPeerID, m.ID, fmt.Sprintf("发送 %.0f%s", p*100, "%"))
+				updateChatMessageState(m.FromPeerID, m.ID, ChatMessageState{State: kcdb.ChatMessageStateSend, Progress: p}, false)
 			},
 		)
+	default:
+		se = sendMessageText(m.ToPeerID, m.Text)
 	}
 
 	if se != nil {
-		// 保存入库
-		kcdb.ChatMessageInfoUpdateState(m.ID, "失败")
-		// 订阅回调
-		feedCallback.FeedCallbackOnChatMessageState(m.FromPeerID, m.ID, "失败")
+		updateChatMessageState(m.FromPeerID, m.ID, ChatMessageState{State: kcdb.ChatMessageStateFail, Error: se.Error()}, true)
 		return
 	}
 
-	// 保存入库
-	kcdb.ChatMessageInfoUpdateState(m.ID, "完成")
+	updateChatMessageState(m.FromPeerID, m.ID, ChatMessageState{State: kcdb.ChatMessageStateDone, Progress: 1}, true)
+}
+
+// 更新会话消息状态并推送(save为false时只推送不入库, 用于传输进度)
+func updateChatMessageState(peerID string, messageID int64, state ChatMessageState, save bool) {
+	if save {
+		e := kcdb.ChatMessageInfoUpdateState(messageID, state.State, state.Progress, state.Error)
+		if e != nil {
+			log.Println("保存会话消息状态出错", e)
+		}
+	}
+
 	// 订阅回调
-	feedCallback.FeedCallbackOnChatMessageState(m.FromPeerID, m.ID, "完成")
+	jsonBytes, _ := json.Marshal(state)
+	feedCallback.FeedCallbackOnChatMessageState(peerID, messageID, string(jsonBytes))
 }
 
 // 处理交换信息请求
@@ -425,12 +448,11 @@ func infoStreamHandler(s network.Stream) {
 	// 获取我的信息
 	option := kcoption.Get()
 
-	// 检查拒绝名单
-	if valueInArray(remotePeerID.Pretty(), kcoption.Get().BlacklistIDArray) {
-		log.Println("已在拒绝名单中的远程节点:", remotePeerID)
-		resultBytes := []byte("拒绝")
+	// 检查访问权限
+	if result := checkPermission(s, true); result != "" {
+		log.Println("拒绝远程节点:", remotePeerID, result)
+		resultBytes := []byte(result)
 		writeTextToReadWriter(rw, &resultBytes)
-		s.Close()
 		return
 	}
 
@@ -457,35 +479,72 @@ func infoStreamHandler(s network.Stream) {
 		log.Println("解析对方信息出错", e)
 		return
 	}
+	targetInfo.KeyFingerprint = peerKeyFingerprint(remotePeerID.Pretty())
+
+	// 不是联系人时需要我同意, 我请求过对方时视为对方同意
+	// 注意: 陌生节点的头像不保存缓存也不获取, 成为联系人之后再处理.
+	if !kccontact.Has(remotePeerID.Pretty()) {
+		request, exists := kccontact.GetRequest(remotePeerID.Pretty())
+		if !exists || request.Direction != kccontact.RequestDirectionOut {
+			// 头像超过上限时忽略
+			photo := targetInfo.Photo
+			if len(photo) > avatarMaxSize {
+				photo = ""
+			}
+			request := kccontact.Request{ID: remotePeerID.Pretty(), Name: targetInfo.Name, Photo: photo,
+				Direction: kccontact.RequestDirectionIn, Time: time.Now().UnixNano() / 1e6}
+			e = kccontact.SetRequest(request)
+			if e != nil {
+				log.Println("保存联系请求出错", e)
+				return
+			}
+
+			resultBytes := []byte(resultWaitingApproval)
+			writeTextToReadWriter(rw, &resultBytes)
+
+			// 执行订阅回调
+			jsonBytes, _ := json.Marshal(request)
+			feedCallback.FeedCallbackOnContactRequest(string(jsonBytes))
+			return
+		}
+
+		kccontact.DelRequest(remotePeerID.Pretty())
+	}
+	resolveRemoteAvatar(remotePeerID.Pretty(), &targetInfo)
 
 	// 回复我的信息
-	data, _ := json.Marshal(kccontact.Contact{ID: h.ID().Pretty(), Name: option.Name, Photo: option.Photo})
-	e = writeTextToReadWriter(rw, &data)
-	if e != nil {
-		log.Println("回复我的信息出错", e)
+	data, _ := json.Marshal(myInfo(option, remotePeerID))
+	replyError := writeTextToReadWriter(rw, &data)
+	if replyError != nil {
+		log.Println("回复我的信息出错", replyError)
 	}
 
-	// 保存对方信息
+	// 保存对方信息(保留名字备注等本地字段)
 	targetInfo.ID = remotePeerID.Pretty()
-	if kccontact.Has(targetInfo.ID) {
-		targetInfo.NameRemark = kccontact.Get(targetInfo.ID).NameRemark // 保留名字备注
+	contact, e := kccontact.SetRemote(targetInfo)
+	if e != nil {
+		log.Println("保存对方信息出错", e)
+		return
+	}
+	if replyError == nil {
+		markInfoSent(contact.ID, option.InfoVersion)
 	}
-	kccontact.Set(targetInfo)
 
 	// 执行订阅回调
-	jsonBytes, _ := json.Marshal(targetInfo)
+	jsonBytes, _ := json.Marshal(*contact)
 	feedCallback.FeedCallbackOnContactUpdate(string(jsonBytes))
 }
 
 // 交换信息(成功时自动保存)
+// 注意: 对方需要同意时返回errWaitingApproval.
 func exchangeInfo(id string) (*kccontact.Contact, error) {
 	// 获取我的信息
 	option := kcoption.Get()
 	if option.Name == "" {
 		return nil, fmt.Errorf("我的名字没有设置")
 	}
-	if valueInArray(id, kcoption.Get().BlacklistIDArray) {
-		return nil, fmt.Errorf("对方正在黑名单中, 请先从黑名单中移除")
+	if kcblock.Has(id, time.Now().UnixNano()/1e6) {
+		return nil, fmt.Errorf("对方在拒绝名单中, 请先移出拒绝名单")
 	}
 
 	// 创建流
@@ -499,7 +558,7 @@ func exchangeInfo(id string) (*kccontact.Contact, error) {
 	rw := bufio.NewReadWriter(bufio.NewReader(s), bufio.NewWriter(s))
 
 	// 发送我的信息
-	data, _ := json.Marshal(kccontact.Contact{ID: h.ID().Pretty(), Name: option.Name, Photo: option.Photo})
+	data, _ := json.Marshal(myInfo(option, s.Conn().RemotePeer()))
 	e = writeTextToReadWriter(rw, &data)
 	if e != nil {
 		return nil, e
@@ -512,10 +571,12 @@ func exchangeInfo(id string) (*kccontact.Contact, error) {
 	}
 
 	// 检查异常状态
-	if string(*resultBytes) == "拒绝" {
-		return nil, fmt.Errorf("拒绝")
+	if e := refuseError(string(*resultBytes)); e != nil {
+		return nil, e
 	} else if string(*resultBytes) == "没有信息" {
 		return nil, fmt.Errorf("没有信息")
+	} else if string(*resultBytes) == resultWaitingApproval {
+		return nil, errWaitingApproval
 	}
 
 	// 解析对方信息
@@ -525,15 +586,21 @@ func exchangeInfo(id string) (*kccontact.Contact, error) {
 		return nil, e
 	}
 
-	// 保存对方信息
+	// 保存对方信息(保留名字备注等本地字段)
 	targetInfo.ID = id
-	kccontact.Set(targetInfo)
+	targetInfo.KeyFingerprint = peerKeyFingerprint(id)
+	resolveRemoteAvatar(id, &targetInfo)
+	contact, e := kccontact.SetRemote(targetInfo)
+	if e != nil {
+		return nil, e
+	}
+	markInfoSent(id, option.InfoVersion)
 
 	// 执行订阅回调
-	jsonBytes, _ := json.Marshal(targetInfo)
+	jsonBytes, _ := json.Marshal(*contact)
 	feedCallback.FeedCallbackOnContactUpdate(string(jsonBytes))
 
-	return &targetInfo, nil
+	return contact, nil
 }
 
 // Start 启动
@@ -543,6 +610,7 @@ func Start(safeDir, fileDir string, port int, callback FeedCallback) error {
 	log.Printf("节点启动-安全目录:%s, 文件目录:%s, 端口:%d", safeDir, fileDir, port)
 
 	fileDirectory = fileDir
+	avatarDirectory = filepath.Join(safeDir, "avatar")
 	feedCallback = callback
 
 	// 加载(创建)密钥
@@ -551,27 +619,68 @@ func Start(safeDir, fileDir string, port int, callback FeedCallback) error {
 		return e
 	}
 
+	// 创建数据库
+	databaseSecretBytes, e := databaseSecret(databasePassphrase, *privateKey)
+	if e != nil {
+		return fmt.Errorf("获取数据库密钥出错: %w", e)
+	}
+	e = kcdb.Open(filepath.Join(safeDir, "db.sqlite"), databaseSecretBytes)
+	if e != nil {
+		return fmt.Errorf("创建数据库出错: %w", e)
+	}
+	// 结束上次运行没有断开的在线记录
+	e = kcdb.PresenceCloseAll(0)
+	if e != nil {
+		log.Println("结束在线记录出错", e)
+	}
+	// 上次运行没有完成的传输设为失败(清理时才会删除)
+	e = kcdb.ChatMessageInfoFailUnfinished("传输中断")
+	if e != nil {
+		log.Println("更新没有完成的会话消息出错", e)
+	}
+
+	// 创建选项(导入旧版JSON文件)
+	e = kcoption.New(filepath.Join(safeDir, "option.json"))
+	if e != nil {
+		return fmt.Errorf("创建选项出错: %w", e)
+	}
+	// 创建联系人(导入旧版JSON文件)
+	e = kccontact.New(filepath.Join(safeDir, "contact.json"))
+	if e != nil {
+		return fmt.Errorf("创建联系人出错: %w", e)
+	}
+	// 创建拒绝名单(迁移旧版黑名单)
+	e = kcblock.New()
+	if e != nil {
+		return fmt.Errorf("创建拒绝名单出错: %w", e)
+	}
+	e = migrateBlacklist()
+	if e != nil {
+		return fmt.Errorf("迁移黑名单出错: %w", e)
+	}
+
+	// 私有网络(需要在监听和传输之前, 私有网络不支持QUIC)
+	privateNetworkOptionArray, e := privateNetworkOptions(safeDir)
+	if e != nil {
+		return e
+	}
+
+	// 监听和传输
+	listenOptionArray, e := listenOptions(kcoption.Get(), port)
+	if e != nil {
+		return e
+	}
+	listenOptionArray = append(listenOptionArray, privateNetworkOptionArray...)
+
 	// 上下文控制libp2p节点的生命周期, 取消它可以停止节点.
 	ctx, ctxCancel = context.WithCancel(context.Background())
 
 	// 创建主机
-	h, e = libp2p.New(ctx,
+	h, e = libp2p.New(ctx, append(listenOptionArray,
 		// Use the keypair we generated
 		libp2p.Identity(*privateKey),
-		// // 没有地址
-		// libp2p.ListenAddrs(),
-		// Multiple listen addresses
-		libp2p.ListenAddrStrings(
-			fmt.Sprint("/ip4/0.0.0.0/tcp/", port),          // regular tcp connections
-			fmt.Sprint("/ip4/0.0.0.0/udp/", port, "/quic"), // a UDP endpoint for the QUIC transport
-			fmt.Sprint("/ip6/::/udp/", port, "/quic"),      // a UDP endpoint for the QUIC transport
-		),
 		// support TLS connections
 		libp2p.Security(libp2ptls.ID, libp2ptls.New),
-		// support any other default transports (TCP)
-		libp2p.DefaultTransports,
-		// support QUIC - experimental
-		libp2p.Transport(libp2pquic.NewTransport),
 		// Let's prevent our peer from having too many
 		// connections by attaching a connection manager.
 		libp2p.ConnectionManager(connmgr.NewConnManager(
@@ -580,7 +689,7 @@ func Start(safeDir, fileDir string, port int, callback FeedCallback) error {
 			time.Minute, // GracePeriod
 		)),
 		// Attempt to open ports using uPNP for NATed hosts.
-		libp2p.NATPortMap(),
+		natPortMapOption(),
 		// Let this host use the DHT to find other hosts
 		libp2p.Routing(func(h host.Host) (routing.PeerRouting, error) {
 			idht, e = dht.New(ctx, h)
@@ -590,26 +699,38 @@ func Start(safeDir, fileDir string, port int, callback FeedCallback) error {
 		// it finds it is behind NAT. Use libp2p.Relay(options...) to
 		// enable active relays and more.
 		libp2p.EnableAutoRelay(),
-	)
+		// 拒绝名单中的节点在连接层拒绝
+		libp2p.ConnectionGater(&blockGater{}),
+	)...)
 	if e != nil {
 		return fmt.Errorf("创建主机出错: %w", e)
 	}
 
-	// 创建数据库
-	e = kcdb.Open(filepath.Join(safeDir, "db.sqlite"))
+	// 订阅网络事件(用于网络诊断)
+	e = watchNetworkEvent()
 	if e != nil {
-		return fmt.Errorf("创建数据库出错: %w", e)
+		return fmt.Errorf("订阅网络事件出错: %w", e)
+	}
+	// 联系人连接状态(连接建立和断开时立即通知)
+	h.Network().Notify(connStateNotifiee())
+	// 保存联系人地址(用于重新连接)
+	e = watchPeerIdentify()
+	if e != nil {
+		return fmt.Errorf("订阅节点识别出错: %w", e)
+	}
+	// 网络变化时重新连接
+	e = watchNetworkChange()
+	if e != nil {
+		return fmt.Errorf("订阅网络变化出错: %w", e)
 	}
-
-	// 创建选项
-	kcoption.New(filepath.Join(safeDir, "option.json"))
-	// 创建联系人
-	kccontact.New(filepath.Join(safeDir, "contact.json"))
 
 	// 设置流处
 	h.SetStreamHandler(protocolIDInfo, infoStreamHandler)
 	h.SetStreamHandler(protocolIDMessageText, messageTextStreamHandler)
 	h.SetStreamHandler(protocolIDMessageFile, messageFileStreamHandler)
+	h.SetStreamHandler(protocolIDMessageSetting, messageSettingStreamHandler)
+	h.SetStreamHandler(protocolIDMessageContact, messageContactStreamHandler)
+	h.SetStreamHandler(protocolIDAvatar, avatarStreamHandler)
 	h.SetStreamHandler(protocolIDRemoteControlMessage, remoteControlMessageStreamHandler)
 	h.SetStreamHandler(protocolIDRemoteControlVideo, remoteControlVideoStreamHandler)
 
@@ -619,14 +740,10 @@ func Start(safeDir, fileDir string, port int, callback FeedCallback) error {
 		return fmt.Errorf("创建公网穿透出错: %w", e)
 	}
 
-	// 连接引导节点, 帮助节点之间发现
-	go connectBootstrap("/ip4/104.131.131.82/tcp/4001/p2p/QmaCpDMGvV2BGHeYERUEnRQAwe3N8SzbUtfsmvsqQLuvuJ")
-	go connectBootstrap("/ip4/47.74.132.162/udp/6666/quic/p2p/12D3KooWFTmSZHheeSjGvnZzBtK7UHQUQTA3XNjMwz5bEqTTRPPA")
-	// 注意：android中使用dnsaddr时无法连接。
-	// go connectBootstrap("/dnsaddr/bootstrap.pl.app.lilu.red/p2p/12D3KooWFTmSZHheeSjGvnZzBtK7UHQUQTA3XNjMwz5bEqTTRPPA")
-	for _, v := range kcoption.Get().BootstrapArray {
-		go connectBootstrap(v)
-	}
+	// 连接引导节点, 帮助节点之间发现(断开后按退避间隔重试)
+	bootstrapCheck(true)
+	bootstrapTicker = time.NewTicker(time.Second * 10)
+	go bootstrapTickerTask()
 
 	// 创建mDNS服务并注册发现列队, 帮助局域网内节点相互发现
 	// 注意: mDNS依赖tcp传输!
@@ -643,9 +760,11 @@ func Start(safeDir, fileDir string, port int, callback FeedCallback) error {
 			case <-stopChan:
 				return
 			case addrInfo := <-mdn.PeerChan:
-				if valueInArray(addrInfo.ID.Pretty(), kcoption.Get().BlacklistIDArray) {
+				if kcblock.Has(addrInfo.ID.Pretty(), time.Now().UnixNano()/1e6) {
 					break
 				}
+				// 内网发现的联系人不再等待退避
+				reconnectReset(addrInfo.ID.Pretty())
 
 				connCount := len(h.Network().ConnsToPeer(addrInfo.ID))
 				if connCount == 0 {
@@ -662,18 +781,14 @@ func Start(safeDir, fileDir string, port int, callback FeedCallback) error {
 						h.ConnManager().TagPeer(addrInfo.ID, connProtectTagKeep, 100)
 						h.ConnManager().Protect(addrInfo.ID, connProtectTagKeep)
 
-						// 交换信息
-						_, e = exchangeInfo(addrInfo.ID.Pretty())
-						if e != nil {
-							log.Println("内网节点交换信息失败", e)
+						// 交换信息(只更新联系人, 陌生节点需要通过联系请求添加)
+						if kccontact.Has(addrInfo.ID.Pretty()) {
+							_, e = exchangeInfo(addrInfo.ID.Pretty())
+							if e != nil {
+								log.Println("内网节点交换信息失败", e)
+							}
 						}
 					}
-				} else if !kccontact.Has(addrInfo.ID.Pretty()) {
-					//已经连接但是没有交换信息时交换
-					_, e = exchangeInfo(addrInfo.ID.Pretty())
-					if e != nil {
-						log.Println("内网节点交换信息失败", e)
-					}
 				}
 			}
 		}
@@ -683,6 +798,14 @@ func Start(safeDir, fileDir string, port int, callback FeedCallback) error {
 	connStateTicker = time.NewTicker(time.Second * 6)
 	go connStateTickerTask()
 
+	// 开始连接详情推送
+	peerConnectionTicker = time.NewTicker(peerConnectionInterval)
+	go peerConnectionTickerTask()
+
+	// 开始会话消息清理
+	retentionTicker = time.NewTicker(retentionInterval)
+	go retentionTickerTask()
+
 	// 标记就绪
 	ready = true
 
@@ -696,17 +819,50 @@ func Start(safeDir, fileDir string, port int, callback FeedCallback) error {
 func Stop() {
 	log.Println("停止")
 	ready = false
+
+	// 服务器模式只需要停止主机
+	if serverMode {
+		if ctxCancel != nil {
+			ctxCancel()
+		}
+		return
+	}
+
 	stopChan <- 1
 
 	// 停止连接状态检查
 	connStateTickerStopChan <- true
 	connStateTicker.Stop()
 
+	// 停止连接详情推送
+	peerConnectionTickerStopChan <- true
+	peerConnectionTicker.Stop()
+
+	// 停止引导
+	bootstrapTickerStopChan <- true
+	bootstrapTicker.Stop()
+
+	// 停止会话消息清理
+	retentionTickerStopChan <- true
+	retentionTicker.Stop()
+
+	// 结束在线记录
+	e := kcdb.PresenceCloseAll(time.Now().UnixNano() / 1e6)
+	if e != nil {
+		log.Println("结束在线记录出错", e)
+	}
+
 	kcdb.Close()
 
 	ctxCancel()
 }
 
+// SetDatabasePassphrase 设置数据库口令
+// 注意: 需要在启动前调用, 为空时使用节点密钥派生数据库密钥.
+func SetDatabasePassphrase(passphrase string) {
+	databasePassphrase = passphrase
+}
+
 // IDValid 检查ID是否正确, 没有异常说明正确
 func IDValid(peerID string) error {
 	_, e := peer.Decode(peerID)
@@ -0,0 +1,144 @@
+package kc
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	kcoption "github.com/alx696/polong-core/kc/option"
+	"github.com/libp2p/go-libp2p"
+	libp2pquic "github.com/libp2p/go-libp2p-quic-transport"
+	"github.com/multiformats/go-multiaddr"
+)
+
+// 传输
+const (
+	// TCP(包括WebSocket), mDNS依赖TCP
+	TransportTCP = "tcp"
+	// QUIC
+	TransportQUIC = "quic"
+)
+
+// 默认监听地址(所有网卡, IPv4和IPv6的TCP和QUIC)
+func defaultListenAddrArray(port int) []string {
+	return []string{
+		fmt.Sprint("/ip4/0.0.0.0/tcp/", port),
+		fmt.Sprint("/ip6/::/tcp/", port),
+		fmt.Sprint("/ip4/0.0.0.0/udp/", port, "/quic"),
+		fmt.Sprint("/ip6/::/udp/", port, "/quic"),
+	}
+}
+
+// 获取监听地址使用的传输
+func listenAddrTransport(multiaddrText string) (string, error) {
+	multiAddr, e := multiaddr.NewMultiaddr(multiaddrText)
+	if e != nil {
+		return "", fmt.Errorf("多址字符错误: %w", e)
+	}
+
+	protocols := multiAddr.Protocols()
+	switch protocols[0].Code {
+	case multiaddr.P_IP4, multiaddr.P_IP6:
+	default:
+		return "", fmt.Errorf("监听地址需要以ip4或ip6开头")
+	}
+	if _, e := multiAddr.ValueForProtocol(multiaddr.P_P2P); e == nil {
+		return "", fmt.Errorf("监听地址不能包含节点ID")
+	}
+	if _, e := multiAddr.ValueForProtocol(multiaddr.P_QUIC); e == nil {
+		return TransportQUIC, nil
+	}
+	if _, e := multiAddr.ValueForProtocol(multiaddr.P_TCP); e == nil {
+		return TransportTCP, nil
+	}
+	return "", fmt.Errorf("不支持的传输")
+}
+
+// 传输是否启用(传输数组为空时全部启用, 私有网络不支持QUIC)
+func transportEnabled(option *kcoption.Option, transport string) bool {
+	if transport == TransportQUIC && privateNetwork {
+		return false
+	}
+	return len(option.TransportArray) == 0 || valueInArray(transport, option.TransportArray)
+}
+
+// 根据选项创建监听和传输的libp2p选项, 监听地址为空时使用默认地址
+func listenOptions(option *kcoption.Option, port int) ([]libp2p.Option, error) {
+	addrArray := option.ListenAddrArray
+	if len(addrArray) == 0 {
+		addrArray = defaultListenAddrArray(port)
+	}
+
+	// 只监听启用的传输
+	var listenArray []string
+	for _, v := range addrArray {
+		transport, e := listenAddrTransport(v)
+		if e != nil {
+			return nil, fmt.Errorf("监听地址错误: %s, %w", v, e)
+		}
+		if transportEnabled(option, transport) {
+			listenArray = append(listenArray, v)
+		}
+	}
+	if len(listenArray) == 0 {
+		return nil, fmt.Errorf("没有可用的监听地址")
+	}
+	log.Println("监听地址", listenArray)
+
+	options := []libp2p.Option{libp2p.ListenAddrStrings(listenArray...)}
+	if transportEnabled(option, TransportTCP) {
+		// support any other default transports (TCP)
+		options = append(options, libp2p.DefaultTransports)
+	} else {
+		log.Println("没有启用TCP, 内网发现无法使用")
+	}
+	if transportEnabled(option, TransportQUIC) {
+		// support QUIC - experimental
+		options = append(options, libp2p.Transport(libp2pquic.NewTransport))
+	}
+	return options, nil
+}
+
+// SetListenAddrArray 设置监听地址(多址, 逗号分隔), 为空时使用默认地址
+// 注意: 重新启动后生效.
+func SetListenAddrArray(arrayText string) error {
+	array := []string{}
+	if arrayText != "" {
+		array = strings.Split(arrayText, ",")
+	}
+
+	for _, v := range array {
+		//检查地址
+		_, e := listenAddrTransport(v)
+		if e != nil {
+			return fmt.Errorf("监听地址错误: %s, %w", v, e)
+		}
+	}
+
+	_, e := kcoption.Update(func(option *kcoption.Option) {
+		option.ListenAddrArray = array
+	})
+	return e
+}
+
+// SetTransportArray 设置启用的传输(tcp, quic, 逗号分隔), 为空时全部启用
+// 注意: 重新启动后生效.
+func SetTransportArray(arrayText string) error {
+	array := []string{}
+	if arrayText != "" {
+		array = strings.Split(arrayText, ",")
+	}
+
+	for _, v := range array {
+		switch v {
+		case TransportTCP, TransportQUIC:
+		default:
+			return fmt.Errorf("传输错误: %s", v)
+		}
+	}
+
+	_, e := kcoption.Update(func(option *kcoption.Option) {
+		option.TransportArray = array
+	})
+	return e
+}
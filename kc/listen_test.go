@@ -0,0 +1,27 @@
+package kc
+
+import "testing"
+
+func TestListenAddrTransport(t *testing.T) {
+	tests := []struct {
+		addr      string
+		transport string
+		ok        bool
+	}{
+		{"/ip4/0.0.0.0/tcp/6666", TransportTCP, true},
+		{"/ip6/::/tcp/6666", TransportTCP, true},
+		{"/ip4/0.0.0.0/tcp/6666/ws", TransportTCP, true},
+		{"/ip4/0.0.0.0/udp/6666/quic", TransportQUIC, true},
+		{"/ip6/::/udp/6666/quic", TransportQUIC, true},
+		{"/ip4/0.0.0.0/udp/6666", "", false},
+		{"/dns4/example.com/tcp/6666", "", false},
+		{"/ip4/1.2.3.4/tcp/6666/p2p/12D3KooWFTmSZHheeSjGvnZzBtK7UHQUQTA3XNjMwz5bEqTTRPPA", "", false},
+		{"tcp/6666", "", false},
+	}
+	for _, v := range tests {
+		transport, e := listenAddrTransport(v.addr)
+		if (e == nil) != v.ok || transport != v.transport {
+			t.Errorf("%s: 结果 %q, %v", v.addr, transport, e)
+		}
+	}
+}
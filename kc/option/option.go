@@ -2,56 +2,101 @@ package option
 
 import (
 	"encoding/json"
+	"fmt"
 	"io/ioutil"
 	"os"
 	"sync"
+
+	kcdb "github.com/alx696/polong-core/kc/db"
 )
 
 // Option 选项
 type Option struct {
-	Name             string   `json:"name"`
-	Photo            string   `json:"photo"`
-	BootstrapArray   []string `json:"bootstrap_array"`    //引导地址
-	BlacklistIDArray []string `json:"blacklist_id_array"` //黑名单节点ID
+	Name                     string   `json:"name"`
+	Photo                    string   `json:"photo"`
+	InfoVersion              int64    `json:"info_version"`               //我的信息版本(修改名字或头像时更新)
+	BootstrapArray           []string `json:"bootstrap_array"`            //引导地址
+	DefaultBootstrapDisabled bool     `json:"default_bootstrap_disabled"` //是否禁用内置引导地址
+	BlacklistIDArray         []string `json:"blacklist_id_array"`         //黑名单节点ID(旧版, 启动时迁移到拒绝名单)
+	RetentionDays            int64    `json:"retention_days"`             //会话消息保留天数, 0表示永久保留
+	PrivacyMode              string   `json:"privacy_mode"`               //隐私模式, 为空时等同PrivacyModeOpen
+	AllowlistIDArray         []string `json:"allowlist_id_array"`         //允许名单节点ID(隐私模式为PrivacyModeAllowlist时使用)
+	ListenAddrArray          []string `json:"listen_addr_array"`          //监听地址, 为空时使用默认地址
+	TransportArray           []string `json:"transport_array"`            //启用的传输(tcp, quic), 为空时全部启用
 }
 
+// 隐私模式
+const (
+	// 开放: 拒绝名单以外的节点都可以发送消息
+	PrivacyModeOpen = "open"
+	// 仅联系人: 只有联系人可以发送消息, 陌生节点可以发送联系请求
+	PrivacyModeContact = "contact"
+	// 允许名单: 只有允许名单中的节点可以发送消息和联系请求
+	PrivacyModeAllowlist = "allowlist"
+)
+
+const (
+	// 数据库存储分组
+	storeBucket = "option"
+	// 数据库存储键
+	storeKey = "option"
+)
+
 var sm sync.RWMutex
 var data Option
-var path string
 
-// 保存联系人
-func save() error {
-	jsonBytes, e := json.Marshal(data)
+// 从旧版JSON文件导入数据库, 导入后文件重命名为备份
+func importJSON(jsonPath string) error {
+	jsonBytes, e := ioutil.ReadFile(jsonPath)
+	if os.IsNotExist(e) {
+		return nil
+	}
 	if e != nil {
 		return e
 	}
-	e = ioutil.WriteFile(path, jsonBytes, os.ModePerm)
+
+	var jsonData Option
+	e = json.Unmarshal(jsonBytes, &jsonData)
 	if e != nil {
 		return e
 	}
-	return nil
+	e = save(jsonData)
+	if e != nil {
+		return e
+	}
+
+	return os.Rename(jsonPath, jsonPath+".imported")
+}
+
+// 保存选项
+func save(newData Option) error {
+	valueBytes, _ := json.Marshal(newData)
+	return kcdb.StoreSet(storeBucket, storeKey, string(valueBytes))
 }
 
-// New 创建存储
-func New(filePath string) error {
-	data = Option{BootstrapArray: []string{}, BlacklistIDArray: []string{}}
-	path = filePath
+// New 创建存储(数据库需要先打开)
+// jsonPath为旧版JSON文件路径, 文件存在时导入数据库.
+func New(jsonPath string) error {
+	sm.Lock()
+	defer sm.Unlock()
+
+	data = Option{BootstrapArray: []string{}, BlacklistIDArray: []string{}, AllowlistIDArray: []string{},
+		ListenAddrArray: []string{}, TransportArray: []string{}}
 
-	_, e := os.Stat(path)
+	e := importJSON(jsonPath)
 	if e != nil {
-		return save()
+		return fmt.Errorf("导入选项出错: %w", e)
 	}
 
-	jsonBytes, e := ioutil.ReadFile(path)
+	value, e := kcdb.StoreGet(storeBucket, storeKey)
 	if e != nil {
 		return e
 	}
-	e = json.Unmarshal(jsonBytes, &data)
-	if e != nil {
-		return e
+	if value == "" {
+		return nil
 	}
 
-	return nil
+	return json.Unmarshal([]byte(value), &data)
 }
 
 // Get 获取指定(返回的是副本)
@@ -63,10 +108,32 @@ func Get() *Option {
 }
 
 // Set 设置
-func Set(newData Option) {
-	dataClone := newData
+func Set(newData Option) error {
+	sm.Lock()
+	defer sm.Unlock()
+
+	e := save(newData)
+	if e != nil {
+		return e
+	}
+
+	data = newData
+	return nil
+}
+
+// Update 在锁内修改并保存, 防止并发修改相互覆盖
+func Update(fn func(option *Option)) (*Option, error) {
 	sm.Lock()
-	data = dataClone
-	sm.Unlock()
-	save()
+	defer sm.Unlock()
+
+	newData := data
+	fn(&newData)
+	e := save(newData)
+	if e != nil {
+		return nil, e
+	}
+
+	data = newData
+	dataClone := data
+	return &dataClone, nil
 }
@@ -0,0 +1,144 @@
+package kc
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	kccontact "github.com/alx696/polong-core/kc/contact"
+	kcdb "github.com/alx696/polong-core/kc/db"
+	"github.com/libp2p/go-libp2p-core/event"
+	"github.com/libp2p/go-libp2p-core/network"
+	"github.com/libp2p/go-libp2p-core/peer"
+	swarm "github.com/libp2p/go-libp2p-swarm"
+	"github.com/multiformats/go-multiaddr"
+	manet "github.com/multiformats/go-multiaddr/net"
+)
+
+// 没有保存的地址
+var errNoPeerAddr = errors.New("没有保存的地址")
+
+// 地址是否可以保存(不保存中继和本机回环地址)
+func peerAddrSavable(addr multiaddr.Multiaddr) bool {
+	if _, e := addr.ValueForProtocol(multiaddr.P_CIRCUIT); e == nil {
+		return false
+	}
+	return !manet.IsIPLoopback(addr)
+}
+
+// 订阅节点识别完成事件, 保存联系人的监听地址, 主机停止时结束
+func watchPeerIdentify() error {
+	sub, e := h.EventBus().Subscribe(new(event.EvtPeerIdentificationCompleted))
+	if e != nil {
+		return e
+	}
+
+	go func() {
+		defer sub.Close()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case evt, ok := <-sub.Out():
+				if !ok {
+					return
+				}
+				savePeerAddrSeen(evt.(event.EvtPeerIdentificationCompleted).Peer)
+			}
+		}
+	}()
+	return nil
+}
+
+// 保存联系人在节点仓库中的地址
+func savePeerAddrSeen(peerID peer.ID) {
+	id := peerID.Pretty()
+	if !kccontact.Has(id) {
+		return
+	}
+
+	var addrArray []string
+	for _, v := range h.Peerstore().Addrs(peerID) {
+		if peerAddrSavable(v) {
+			addrArray = append(addrArray, v.String())
+		}
+	}
+	if len(addrArray) == 0 {
+		return
+	}
+
+	e := kcdb.PeerAddrSeen(id, addrArray, time.Now().UnixNano()/1e6)
+	if e != nil {
+		log.Println("保存节点地址出错", e)
+	}
+}
+
+// 保存联系人当前连接成功的地址(只有主动连接的地址可以再次拨号)
+func savePeerAddrSuccess(peerID peer.ID) {
+	var addrArray []string
+	for _, conn := range h.Network().ConnsToPeer(peerID) {
+		if conn.Stat().Direction == network.DirOutbound && peerAddrSavable(conn.RemoteMultiaddr()) {
+			addrArray = append(addrArray, conn.RemoteMultiaddr().String())
+		}
+	}
+	if len(addrArray) == 0 {
+		return
+	}
+
+	e := kcdb.PeerAddrSuccess(peerID.Pretty(), addrArray, time.Now().UnixNano()/1e6)
+	if e != nil {
+		log.Println("保存节点地址出错", e)
+	}
+}
+
+// 使用保存的地址连接节点, 失败时记录失败次数
+func connectStoredPeer(peerID peer.ID) error {
+	id := peerID.Pretty()
+	peerAddrArray, e := kcdb.PeerAddrFind(id)
+	if e != nil {
+		return e
+	}
+
+	addrInfo := peer.AddrInfo{ID: peerID}
+	for _, v := range peerAddrArray {
+		addr, e := multiaddr.NewMultiaddr(v.Addr)
+		if e != nil {
+			continue
+		}
+		addrInfo.Addrs = append(addrInfo.Addrs, addr)
+	}
+	if len(addrInfo.Addrs) == 0 {
+		return errNoPeerAddr
+	}
+
+	localContext, localContextCancel := context.WithTimeout(ctx, time.Second*2)
+	defer localContextCancel()
+	e = h.Connect(localContext, addrInfo)
+	if e != nil {
+		clearPeerNetworkCache(peerID)
+		if failError := kcdb.PeerAddrFail(id, dialFailAddrArray(e, addrInfo.Addrs), time.Now().UnixNano()/1e6); failError != nil {
+			log.Println("保存节点地址出错", failError)
+		}
+		return e
+	}
+
+	return nil
+}
+
+// 获取连接失败的地址: 拨号错误中有每个地址的错误时使用, 否则(超时等)视为全部失败
+func dialFailAddrArray(e error, addrArray []multiaddr.Multiaddr) []string {
+	var array []string
+	var dialError *swarm.DialError
+	if errors.As(e, &dialError) {
+		for _, v := range dialError.DialErrors {
+			array = append(array, v.Address.String())
+		}
+	}
+	if len(array) == 0 {
+		for _, v := range addrArray {
+			array = append(array, v.String())
+		}
+	}
+	return array
+}
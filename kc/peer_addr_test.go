@@ -0,0 +1,30 @@
+package kc
+
+import (
+	"testing"
+
+	"github.com/multiformats/go-multiaddr"
+)
+
+func TestPeerAddrSavable(t *testing.T) {
+	tests := []struct {
+		addr string
+		want bool
+	}{
+		{"/ip4/192.168.1.2/tcp/6666", true},
+		{"/ip4/1.2.3.4/udp/6666/quic", true},
+		{"/ip6/2001:db8::1/tcp/6666", true},
+		{"/ip4/127.0.0.1/tcp/6666", false},
+		{"/ip6/::1/tcp/6666", false},
+		{"/ip4/1.2.3.4/tcp/4001/p2p/12D3KooWFTmSZHheeSjGvnZzBtK7UHQUQTA3XNjMwz5bEqTTRPPA/p2p-circuit", false},
+	}
+	for _, v := range tests {
+		addr, e := multiaddr.NewMultiaddr(v.addr)
+		if e != nil {
+			t.Fatal(v.addr, e)
+		}
+		if got := peerAddrSavable(addr); got != v.want {
+			t.Errorf("%s: 结果 %v, 应为 %v", v.addr, got, v.want)
+		}
+	}
+}
@@ -0,0 +1,175 @@
+package kc
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	kccontact "github.com/alx696/polong-core/kc/contact"
+	"github.com/libp2p/go-libp2p-core/network"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p/p2p/protocol/ping"
+	"github.com/multiformats/go-multiaddr"
+)
+
+// 连接详情推送间隔
+const peerConnectionInterval = time.Second * 30
+
+// 测量延迟超时
+const pingTimeout = time.Second * 5
+
+// PeerConnection 联系人的连接详情
+type PeerConnection struct {
+	ID string `json:"id"`
+	// 往返延迟(毫秒), 测量失败时为-1
+	RTT int64 `json:"rtt"`
+	// 测量失败原因
+	RTTError string `json:"rtt_error"`
+	// 连接
+	ConnArray []ConnDetail `json:"conn_array"`
+}
+
+// ConnDetail 连接详情
+type ConnDetail struct {
+	// 传输: tcp, quic, 无法识别时为空
+	Transport string `json:"transport"`
+	// 是否通过中继
+	Relayed bool `json:"relayed"`
+	// 对方地址
+	RemoteAddr string `json:"remote_addr"`
+	// 本地地址
+	LocalAddr string `json:"local_addr"`
+	// 方向: inbound, outbound
+	Direction string `json:"direction"`
+	// 建立时间(毫秒)
+	OpenedTime int64 `json:"opened_time"`
+}
+
+// 连接详情重复器信道
+var peerConnectionTickerStopChan = make(chan bool, 1)
+
+// 连接详情重复器
+var peerConnectionTicker *time.Ticker
+
+// 连接详情重复器任务: 定时测量延迟并推送
+func peerConnectionTickerTask() {
+	for {
+		select {
+		case <-peerConnectionTickerStopChan:
+			log.Println("连接详情重复器任务结束")
+			return
+		case <-peerConnectionTicker.C:
+			go func() {
+				array := peerConnectionArray()
+				if len(array) == 0 {
+					return
+				}
+				jsonBytes, _ := json.Marshal(array)
+				feedCallback.FeedCallbackOnPeerConnection(string(jsonBytes))
+			}()
+		}
+	}
+}
+
+// 获取连接的传输(根据多址)
+func connTransport(addr multiaddr.Multiaddr) string {
+	if _, e := addr.ValueForProtocol(multiaddr.P_QUIC); e == nil {
+		return TransportQUIC
+	}
+	if _, e := addr.ValueForProtocol(multiaddr.P_TCP); e == nil {
+		return TransportTCP
+	}
+	return ""
+}
+
+// 获取连接详情
+func connDetail(conn network.Conn) ConnDetail {
+	stat := conn.Stat()
+	detail := ConnDetail{
+		Transport:  connTransport(conn.RemoteMultiaddr()),
+		RemoteAddr: conn.RemoteMultiaddr().String(),
+		LocalAddr:  conn.LocalMultiaddr().String(),
+		Direction:  "outbound",
+		OpenedTime: stat.Opened.UnixNano() / 1e6,
+	}
+	if _, e := conn.RemoteMultiaddr().ValueForProtocol(multiaddr.P_CIRCUIT); e == nil {
+		detail.Relayed = true
+	}
+	if stat.Direction == network.DirInbound {
+		detail.Direction = "inbound"
+	}
+	return detail
+}
+
+// 测量往返延迟(毫秒)
+func pingPeer(peerID peer.ID) (int64, error) {
+	localContext, localContextCancel := context.WithTimeout(ctx, pingTimeout)
+	defer localContextCancel()
+
+	result := <-ping.Ping(localContext, h, peerID)
+	if result.Error != nil {
+		return -1, result.Error
+	}
+	return result.RTT.Milliseconds(), nil
+}
+
+// 获取单个节点的连接详情, 没有连接时返回空
+func peerConnection(peerID peer.ID) *PeerConnection {
+	connArray := h.Network().ConnsToPeer(peerID)
+	if len(connArray) == 0 {
+		return nil
+	}
+
+	pc := PeerConnection{ID: peerID.Pretty(), ConnArray: []ConnDetail{}}
+	for _, conn := range connArray {
+		pc.ConnArray = append(pc.ConnArray, connDetail(conn))
+	}
+
+	rtt, e := pingPeer(peerID)
+	pc.RTT = rtt
+	if e != nil {
+		pc.RTTError = e.Error()
+	}
+	return &pc
+}
+
+// 获取所有已连接联系人的连接详情(并发测量延迟)
+func peerConnectionArray() []PeerConnection {
+	var lock sync.Mutex
+	var wg sync.WaitGroup
+	array := []PeerConnection{}
+	for _, id := range *(kccontact.Keys()) {
+		peerID, e := peer.Decode(id)
+		if e != nil {
+			continue
+		}
+
+		wg.Add(1)
+		go func(peerID peer.ID) {
+			defer wg.Done()
+			pc := peerConnection(peerID)
+			if pc == nil {
+				return
+			}
+			lock.Lock()
+			array = append(array, *pc)
+			lock.Unlock()
+		}(peerID)
+	}
+	wg.Wait()
+
+	sort.Slice(array, func(i, j int) bool {
+		return array[i].ID < array[j].ID
+	})
+	return array
+}
+
+// GetPeerConnections 获取已连接联系人的连接详情, 返回PeerConnection数组
+// 注意: 会测量延迟, 最长需要几秒.
+func GetPeerConnections() string {
+	jsonBytes, _ := json.Marshal(peerConnectionArray())
+	return string(jsonBytes)
+}
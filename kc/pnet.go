@@ -0,0 +1,91 @@
+package kc
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p-core/pnet"
+)
+
+// 私有网络密钥文件名(在安全目录中)
+const swarmKeyFileName = "swarm.key"
+
+// 是否在私有网络中(使用私有网络密钥启动)
+// 私有网络不支持QUIC, 也不使用内置引导地址.
+var privateNetwork bool
+
+// 加载私有网络密钥, 文件不存在时返回空
+func loadSwarmKey(safeDir string) (pnet.PSK, error) {
+	keyBytes, e := ioutil.ReadFile(filepath.Join(safeDir, swarmKeyFileName))
+	if os.IsNotExist(e) {
+		return nil, nil
+	}
+	if e != nil {
+		return nil, e
+	}
+
+	psk, e := pnet.DecodeV1PSK(bytes.NewReader(keyBytes))
+	if e != nil {
+		return nil, fmt.Errorf("私有网络密钥错误: %w", e)
+	}
+	return psk, nil
+}
+
+// 根据安全目录中的私有网络密钥创建libp2p选项, 没有密钥时返回空
+func privateNetworkOptions(safeDir string) ([]libp2p.Option, error) {
+	psk, e := loadSwarmKey(safeDir)
+	if e != nil {
+		return nil, e
+	}
+	privateNetwork = psk != nil
+	if !privateNetwork {
+		return nil, nil
+	}
+
+	log.Println("使用私有网络")
+	return []libp2p.Option{libp2p.PrivateNetwork(psk)}, nil
+}
+
+// GenerateSwarmKey 生成私有网络密钥(swarm.key格式文本)
+func GenerateSwarmKey() (string, error) {
+	keyBytes := make([]byte, 32)
+	_, e := rand.Read(keyBytes)
+	if e != nil {
+		return "", e
+	}
+
+	return fmt.Sprintf("/key/swarm/psk/1.0.0/\n/base16/\n%s\n", hex.EncodeToString(keyBytes)), nil
+}
+
+// SetSwarmKey 导入私有网络密钥到安全目录, 为空时删除密钥(退出私有网络)
+// 注意: 重新启动后生效, 同一个私有网络中的设备需要使用相同的密钥.
+func SetSwarmKey(safeDir, keyText string) error {
+	keyPath := filepath.Join(safeDir, swarmKeyFileName)
+	if strings.TrimSpace(keyText) == "" {
+		e := os.Remove(keyPath)
+		if e != nil && !os.IsNotExist(e) {
+			return e
+		}
+		return nil
+	}
+
+	_, e := pnet.DecodeV1PSK(strings.NewReader(keyText))
+	if e != nil {
+		return fmt.Errorf("私有网络密钥错误: %w", e)
+	}
+
+	return ioutil.WriteFile(keyPath, []byte(keyText), 0600)
+}
+
+// IsPrivateNetwork 是否在私有网络中
+func IsPrivateNetwork() bool {
+	return privateNetwork
+}
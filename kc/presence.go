@@ -0,0 +1,40 @@
+package kc
+
+import (
+	"log"
+
+	kcconnstate "github.com/alx696/polong-core/kc/connstate"
+	kccontact "github.com/alx696/polong-core/kc/contact"
+	kcdb "github.com/alx696/polong-core/kc/db"
+)
+
+// 联系人详情中的在线记录数量
+const contactDetailSessionCount = 10
+
+// ContactDetail 联系人详情(联系人信息和在线情况)
+type ContactDetail struct {
+	kccontact.Contact
+	// 是否在线
+	Online bool `json:"online"`
+	// 最后在线时间(毫秒), 0表示从未在线
+	LastSeen int64 `json:"lastSeen"`
+	// 最近的在线记录(按连接时间倒序)
+	Sessions []kcdb.PresenceSession `json:"sessions"`
+}
+
+// 获取联系人详情
+func contactDetail(contact kccontact.Contact) ContactDetail {
+	data := ContactDetail{Contact: contact, Sessions: []kcdb.PresenceSession{}}
+	data.Online, _ = kcconnstate.Get(contact.ID)
+
+	sessions, e := kcdb.PresenceFind(contact.ID, contactDetailSessionCount)
+	if e != nil {
+		log.Println("查询在线记录出错", e)
+		return data
+	}
+	data.Sessions = sessions
+	if len(sessions) > 0 {
+		data.LastSeen = sessions[0].LastSeenTime
+	}
+	return data
+}
@@ -0,0 +1,70 @@
+package kc
+
+import (
+	"errors"
+	"time"
+
+	kcblock "github.com/alx696/polong-core/kc/block"
+	kccontact "github.com/alx696/polong-core/kc/contact"
+	kcoption "github.com/alx696/polong-core/kc/option"
+	"github.com/libp2p/go-libp2p-core/network"
+)
+
+// 拒绝回复(发送方据此判断拒绝原因)
+const (
+	// 在拒绝名单中
+	resultRefuse = "拒绝"
+	// 隐私模式为仅联系人, 对方不是联系人
+	resultRefuseNotContact = "拒绝:非联系人"
+	// 隐私模式为允许名单, 对方不在允许名单中
+	resultRefuseNotAllowed = "拒绝:非允许名单"
+)
+
+// 拒绝错误
+var (
+	errRefuse           = errors.New("拒绝")
+	errRefuseNotContact = errors.New("拒绝: 对方只接收联系人的消息")
+	errRefuseNotAllowed = errors.New("拒绝: 不在对方的允许名单中")
+)
+
+// 检查远程节点是否可以访问, 返回拒绝回复, 允许时返回空字符串
+// stranger为真时不要求是联系人(用于联系请求), 但是仍然检查拒绝名单和允许名单.
+// 拒绝名单中的节点会记录拒绝记录.
+func checkPermission(s network.Stream, stranger bool) string {
+	id := s.Conn().RemotePeer().Pretty()
+	now := time.Now().UnixNano() / 1e6
+	if kcblock.Has(id, now) {
+		// 连接层已经拒绝, 只有加入拒绝名单之前建立的连接会到这里
+		recordBlockAttempt(id, string(s.Protocol()))
+		return resultRefuse
+	}
+
+	option := kcoption.Get()
+
+	switch option.PrivacyMode {
+	case kcoption.PrivacyModeContact:
+		if !stranger && !kccontact.Has(id) {
+			return resultRefuseNotContact
+		}
+	case kcoption.PrivacyModeAllowlist:
+		if !valueInArray(id, option.AllowlistIDArray) {
+			return resultRefuseNotAllowed
+		}
+	}
+
+	return ""
+}
+
+// 拒绝回复转为错误, 不是拒绝回复时返回空
+func refuseError(result string) error {
+	switch result {
+	case resultRefuse:
+		return errRefuse
+	case resultRefuseNotContact:
+		return errRefuseNotContact
+	case resultRefuseNotAllowed:
+		return errRefuseNotAllowed
+	}
+
+	return nil
+}
@@ -0,0 +1,51 @@
+package kc
+
+import (
+	"log"
+
+	kccontact "github.com/alx696/polong-core/kc/contact"
+	kcoption "github.com/alx696/polong-core/kc/option"
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+// 记录对方已经收到的我的信息版本
+func markInfoSent(id string, version int64) {
+	_, e := kccontact.Update(id, func(data *kccontact.Contact) {
+		if data.SentInfoVersion < version {
+			data.SentInfoVersion = version
+		}
+	})
+	if e != nil {
+		log.Println("保存信息推送版本出错", id, e)
+	}
+}
+
+// 对方没有收到最新的我的信息时推送
+func pushInfoIfStale(id string) {
+	option := kcoption.Get()
+	if option.Name == "" || option.InfoVersion == 0 {
+		return
+	}
+	if !kccontact.Has(id) || kccontact.Get(id).SentInfoVersion >= option.InfoVersion {
+		return
+	}
+
+	_, e := exchangeInfo(id)
+	if e != nil {
+		log.Println("推送我的信息失败", id, e)
+	}
+}
+
+// 推送我的信息给已连接的联系人, 未连接的联系人在连上后推送
+func broadcastInfo() {
+	for _, id := range *(kccontact.Keys()) {
+		peerID, e := peer.Decode(id)
+		if e != nil {
+			continue
+		}
+		if len(h.Network().ConnsToPeer(peerID)) == 0 {
+			continue
+		}
+		go pushInfoIfStale(id)
+	}
+}
@@ -0,0 +1,216 @@
+package kc
+
+import (
+	"log"
+	"math/rand"
+	"sync"
+	"time"
+
+	kcblock "github.com/alx696/polong-core/kc/block"
+	kccontact "github.com/alx696/polong-core/kc/contact"
+	"github.com/libp2p/go-libp2p-core/event"
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+const (
+	// 重新连接最小间隔
+	reconnectRetryMin = time.Second * 6
+	// 重新连接最大间隔
+	reconnectRetryMax = time.Minute * 10
+	// 同时连接的数量上限
+	reconnectMaxDial = 4
+	// 网络变化触发重新连接的最小间隔(地址更新事件可能很频繁)
+	reconnectNetworkChangeInterval = time.Second * 30
+)
+
+// 节点重新连接状态
+type reconnectState struct {
+	// 连续失败次数
+	failCount int
+	// 下次连接时间
+	nextDialTime time.Time
+	// 是否正在连接
+	dialing bool
+}
+
+var reconnectLock sync.Mutex
+var reconnectStateMap = make(map[string]*reconnectState)
+
+// 抖动随机数(使用时间作为种子, 防止不同设备的抖动相同), 使用时需要持有reconnectLock
+var reconnectRand = rand.New(rand.NewSource(time.Now().UnixNano()))
+
+// 连接信号量(限制同时连接的数量)
+var reconnectDialChan = make(chan bool, reconnectMaxDial)
+
+// 最后一次网络变化触发时间
+var reconnectNetworkChangeTime time.Time
+
+// 计算重新连接间隔(指数退避, 加减20%抖动防止同时重试)
+// 注意: 调用时需要持有reconnectLock.
+func reconnectDelay(failCount int) time.Duration {
+	delay := reconnectRetryMin
+	for i := 1; i < failCount && delay < reconnectRetryMax; i++ {
+		delay *= 2
+	}
+	if delay > reconnectRetryMax {
+		delay = reconnectRetryMax
+	}
+	return delay - delay/5 + time.Duration(reconnectRand.Int63n(int64(delay*2/5)+1))
+}
+
+// 开始连接: 到达连接时间且有空闲信道时返回真
+func reconnectBegin(id string) bool {
+	reconnectLock.Lock()
+	defer reconnectLock.Unlock()
+
+	state, exists := reconnectStateMap[id]
+	if !exists {
+		state = &reconnectState{}
+		reconnectStateMap[id] = state
+	}
+	if state.dialing || time.Now().Before(state.nextDialTime) {
+		return false
+	}
+
+	select {
+	case reconnectDialChan <- true:
+	default:
+		// 达到上限, 等待下次检查
+		return false
+	}
+	state.dialing = true
+	return true
+}
+
+// 结束连接: 释放信道, 失败时计算下次连接时间
+func reconnectEnd(id string, e error) {
+	<-reconnectDialChan
+
+	reconnectLock.Lock()
+	defer reconnectLock.Unlock()
+
+	state, exists := reconnectStateMap[id]
+	if !exists {
+		return
+	}
+	state.dialing = false
+	if e != nil {
+		state.failCount++
+		state.nextDialTime = time.Now().Add(reconnectDelay(state.failCount))
+		return
+	}
+	state.failCount = 0
+	state.nextDialTime = time.Time{}
+}
+
+// 重置节点的退避(下次检查时立即连接)
+func reconnectReset(id string) {
+	reconnectLock.Lock()
+	defer reconnectLock.Unlock()
+
+	if state, exists := reconnectStateMap[id]; exists {
+		state.failCount = 0
+		state.nextDialTime = time.Time{}
+	}
+}
+
+// 删除节点的重新连接状态(删除联系人时)
+func reconnectForget(id string) {
+	reconnectLock.Lock()
+	defer reconnectLock.Unlock()
+
+	delete(reconnectStateMap, id)
+}
+
+// 重新连接节点(到达连接时间时在后台连接), 成功时更新连接状态
+func reconnectPeer(peerID peer.ID) {
+	id := peerID.Pretty()
+	if !reconnectBegin(id) {
+		return
+	}
+
+	go func() {
+		e := connectDHTPeer(peerID)
+		reconnectEnd(id, e)
+		if e != nil {
+			// log.Println("连接失败", id, e)
+			return
+		}
+
+		// 网络通知已经更新时不会重复
+		connStateUpdate(peerID)
+	}()
+}
+
+// 重置所有联系人的退避并立即重新连接没有连接的联系人(网络变化时)
+func reconnectAll() {
+	reconnectLock.Lock()
+	for _, state := range reconnectStateMap {
+		state.failCount = 0
+		state.nextDialTime = time.Time{}
+	}
+	reconnectLock.Unlock()
+
+	now := time.Now().UnixNano() / 1e6
+	for _, id := range *(kccontact.Keys()) {
+		if kcblock.Has(id, now) {
+			continue
+		}
+		peerID, e := peer.Decode(id)
+		if e != nil {
+			continue
+		}
+		if len(h.Network().ConnsToPeer(peerID)) == 0 {
+			reconnectPeer(peerID)
+		}
+	}
+}
+
+// 订阅本机地址更新事件(网络变化), 主机停止时结束
+func watchNetworkChange() error {
+	sub, e := h.EventBus().Subscribe(new(event.EvtLocalAddressesUpdated))
+	if e != nil {
+		return e
+	}
+
+	go func() {
+		defer sub.Close()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case _, ok := <-sub.Out():
+				if !ok {
+					return
+				}
+				onNetworkChange()
+			}
+		}
+	}()
+	return nil
+}
+
+// 网络变化时重新连接(限制触发频率)
+func onNetworkChange() {
+	reconnectLock.Lock()
+	if time.Since(reconnectNetworkChangeTime) < reconnectNetworkChangeInterval {
+		reconnectLock.Unlock()
+		return
+	}
+	reconnectNetworkChangeTime = time.Now()
+	reconnectLock.Unlock()
+
+	log.Println("网络变化, 重新连接")
+	go bootstrapCheck(true)
+	reconnectAll()
+}
+
+// NotifyNetworkChange 通知网络变化(例如切换WiFi和移动网络), 立即重新连接联系人
+// 注意: 可以随时调用, 没有就绪时忽略.
+func NotifyNetworkChange() {
+	if !ready || h == nil {
+		return
+	}
+
+	onNetworkChange()
+}
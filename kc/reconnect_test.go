@@ -0,0 +1,63 @@
+package kc
+
+import (
+	"math/rand"
+	"testing"
+	"time"
+)
+
+func TestReconnectDelay(t *testing.T) {
+	reconnectLock.Lock()
+	defer reconnectLock.Unlock()
+
+	tests := []struct {
+		failCount int
+		base      time.Duration
+	}{
+		{0, reconnectRetryMin},
+		{1, reconnectRetryMin},
+		{2, reconnectRetryMin * 2},
+		{3, reconnectRetryMin * 4},
+		{7, reconnectRetryMin * 64},
+		{8, reconnectRetryMax},
+		{100, reconnectRetryMax},
+	}
+	for _, v := range tests {
+		// 抖动为加减20%
+		min := v.base - v.base/5
+		max := v.base + v.base/5
+		different := false
+		first := reconnectDelay(v.failCount)
+		for i := 0; i < 100; i++ {
+			got := reconnectDelay(v.failCount)
+			if got < min || got > max {
+				t.Errorf("失败%d次: 结果 %s, 应在 %s 和 %s 之间", v.failCount, got, min, max)
+			}
+			if got != first {
+				different = true
+			}
+		}
+		if !different {
+			t.Errorf("失败%d次: 没有抖动", v.failCount)
+		}
+	}
+}
+
+// 抖动不能使用固定种子(Go 1.20之前全局随机数默认种子为1, 所有设备的抖动相同)
+func TestReconnectDelaySeed(t *testing.T) {
+	reconnectLock.Lock()
+	defer reconnectLock.Unlock()
+
+	fixed := rand.New(rand.NewSource(1))
+	delay := reconnectRetryMax
+	same := true
+	for i := 0; i < 10; i++ {
+		want := delay - delay/5 + time.Duration(fixed.Int63n(int64(delay*2/5)+1))
+		if reconnectDelay(100) != want {
+			same = false
+		}
+	}
+	if same {
+		t.Error("抖动和固定种子的结果相同")
+	}
+}
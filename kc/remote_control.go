@@ -3,11 +3,9 @@ package kc
 import (
 	"bufio"
 	"encoding/json"
-	"fmt"
 	"io"
 	"log"
 
-	kcoption "github.com/alx696/polong-core/kc/option"
 	"github.com/libp2p/go-libp2p-core/network"
 )
 
@@ -33,10 +31,10 @@ func remoteControlMessageStreamHandler(s network.Stream) {
 	// 创建读写器
 	rw := bufio.NewReadWriter(bufio.NewReader(s), bufio.NewWriter(s))
 
-	// 检查拒绝名单
-	if valueInArray(remotePeerID.Pretty(), kcoption.Get().BlacklistIDArray) {
-		log.Println("已在拒绝名单中的远程节点:", remotePeerID)
-		resultBytes := []byte("拒绝")
+	// 检查访问权限
+	if result := checkPermission(s, false); result != "" {
+		log.Println("拒绝远程节点:", remotePeerID, result)
+		resultBytes := []byte(result)
 		writeTextToReadWriter(rw, &resultBytes)
 		return
 	}
@@ -102,8 +100,8 @@ func remoteControlMessageSend(id string, message RemoteControlMessageInfo) error
 	result := string(*resultBytes)
 
 	// 检查异常状态
-	if result == "拒绝" {
-		return fmt.Errorf("拒绝")
+	if e := refuseError(result); e != nil {
+		return e
 	}
 
 	return nil
@@ -111,10 +109,16 @@ func remoteControlMessageSend(id string, message RemoteControlMessageInfo) error
 
 // 处理视频
 func remoteControlVideoStreamHandler(s network.Stream) {
-	// remotePeerID := s.Conn().RemotePeer()
+	remotePeerID := s.Conn().RemotePeer()
 	// log.Println("远程控制视频发送节点:", remotePeerID)
 	defer s.Close()
 
+	// 检查访问权限(视频流没有回复, 直接关闭)
+	if result := checkPermission(s, false); result != "" {
+		log.Println("拒绝远程节点:", remotePeerID, result)
+		return
+	}
+
 	// 创建读写器
 	rw := bufio.NewReadWriter(bufio.NewReader(s), bufio.NewWriter(s))
 
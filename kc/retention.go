@@ -0,0 +1,192 @@
+package kc
+
+import (
+	"bufio"
+	"encoding/json"
+	"log"
+	"time"
+
+	kcdb "github.com/alx696/polong-core/kc/db"
+	kcoption "github.com/alx696/polong-core/kc/option"
+	"github.com/libp2p/go-libp2p-core/network"
+)
+
+// 会话设置消息(阅后即焚需要双方一致)
+type chatSettingMessage struct {
+	DisappearSeconds int64 `json:"disappear_seconds"`
+}
+
+// 会话消息清理间隔(决定阅后即焚的精度)
+const retentionInterval = time.Second * 5
+
+// 会话消息清理重复器信道
+var retentionTickerStopChan = make(chan bool, 1)
+
+// 会话消息清理重复器
+var retentionTicker *time.Ticker
+
+// 会话消息清理重复器任务
+func retentionTickerTask() {
+	for {
+		select {
+		case <-retentionTickerStopChan:
+			log.Println("会话消息清理重复器任务结束")
+			return
+		case <-retentionTicker.C:
+			retentionSweep()
+			blockSweep()
+		}
+	}
+}
+
+// 计算会话消息过期时间(纳秒), 返回0表示不过期
+func retentionCutoff(setting *kcdb.ChatSetting, globalDays int64, now time.Time) int64 {
+	days := globalDays
+	if setting.RetentionDays > 0 {
+		days = setting.RetentionDays
+	}
+
+	var cutoff int64
+	if days > 0 {
+		cutoff = now.Add(-time.Duration(days) * 24 * time.Hour).UnixNano()
+	}
+	if setting.DisappearSeconds > 0 {
+		disappearCutoff := now.Add(-time.Duration(setting.DisappearSeconds) * time.Second).UnixNano()
+		if disappearCutoff > cutoff {
+			cutoff = disappearCutoff
+		}
+	}
+	return cutoff
+}
+
+// 清理过期会话消息
+func retentionSweep() {
+	peerIDs, e := kcdb.ChatMessageInfoPeerIDs(h.ID().Pretty())
+	if e != nil {
+		log.Println("会话消息清理查询节点出错", e)
+		return
+	}
+
+	globalDays := kcoption.Get().RetentionDays
+	now := time.Now()
+	for _, peerID := range peerIDs {
+		setting, e := kcdb.ChatSettingGet(peerID)
+		if e != nil {
+			log.Println("会话消息清理获取设置出错", e)
+			continue
+		}
+
+		cutoff := retentionCutoff(setting, globalDays, now)
+		if cutoff == 0 {
+			continue
+		}
+
+		array, e := kcdb.ChatMessageInfoFindBefore(peerID, cutoff)
+		if e != nil {
+			log.Println("会话消息清理查询消息出错", e)
+			continue
+		}
+		for _, m := range *array {
+			// 正在发送或接收的消息等到完成或失败后再删除, 防止删除正在写入的文件
+			if m.State == kcdb.ChatMessageStateSend || m.State == kcdb.ChatMessageStateReceive {
+				continue
+			}
+
+			removeChatMessageFile(&m)
+			e = kcdb.ChatMessageInfoDeleteByID(m.ID)
+			if e != nil {
+				log.Println("会话消息清理删除消息出错", e)
+				continue
+			}
+
+			// 订阅回调
+			feedCallback.FeedCallbackOnChatMessageDelete(peerID, m.ID)
+		}
+	}
+}
+
+// 处理会话设置消息
+func messageSettingStreamHandler(s network.Stream) {
+	remotePeerID := s.Conn().RemotePeer()
+	log.Println("远程节点会话设置消息:", remotePeerID)
+	defer s.Close()
+
+	// 创建读写器
+	rw := bufio.NewReadWriter(bufio.NewReader(s), bufio.NewWriter(s))
+
+	// 检查访问权限
+	if result := checkPermission(s, false); result != "" {
+		log.Println("拒绝远程节点:", remotePeerID, result)
+		resultBytes := []byte(result)
+		writeTextToReadWriter(rw, &resultBytes)
+		return
+	}
+
+	// 读取
+	requestBytes, e := readTextFromReadWriter(rw)
+	if e != nil {
+		log.Println("读取会话设置消息出错", e)
+		return
+	}
+	var message chatSettingMessage
+	e = json.Unmarshal(*requestBytes, &message)
+	if e != nil || message.DisappearSeconds < 0 {
+		log.Println("解析会话设置消息出错", e)
+		return
+	}
+
+	// 保存
+	setting, e := kcdb.ChatSettingGet(remotePeerID.Pretty())
+	if e == nil {
+		setting.DisappearSeconds = message.DisappearSeconds
+		e = kcdb.ChatSettingSet(setting)
+	}
+	if e != nil {
+		log.Println("保存会话设置出错", e)
+		return
+	}
+
+	// 回复
+	resultBytes := []byte("收到")
+	e = writeTextToReadWriter(rw, &resultBytes)
+	if e != nil {
+		log.Println("读取会话设置消息后写入回复时出错", e)
+	}
+
+	// 执行订阅回调
+	jsonBytes, _ := json.Marshal(setting)
+	feedCallback.FeedCallbackOnChatSettingUpdate(setting.PeerID, string(jsonBytes))
+}
+
+// 发送会话设置消息
+func sendMessageSetting(id string, message chatSettingMessage) error {
+	s, e := createStream(id, protocolIDMessageSetting)
+	if e != nil {
+		return e
+	}
+	defer s.Close()
+
+	// 创建读写器
+	rw := bufio.NewReadWriter(bufio.NewReader(s), bufio.NewWriter(s))
+
+	// 写入
+	data, _ := json.Marshal(message)
+	e = writeTextToReadWriter(rw, &data)
+	if e != nil {
+		return e
+	}
+
+	// 接收
+	resultBytes, e := readTextFromReadWriter(rw)
+	if e != nil {
+		return e
+	}
+	result := string(*resultBytes)
+
+	// 检查异常状态
+	if e := refuseError(result); e != nil {
+		return e
+	}
+
+	return nil
+}
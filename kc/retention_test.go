@@ -0,0 +1,34 @@
+package kc
+
+import (
+	"testing"
+	"time"
+
+	kcdb "github.com/alx696/polong-core/kc/db"
+)
+
+func TestRetentionCutoff(t *testing.T) {
+	now := time.Unix(1600000000, 0)
+	day := 24 * time.Hour
+
+	tests := []struct {
+		name             string
+		retentionDays    int64
+		disappearSeconds int64
+		globalDays       int64
+		want             int64
+	}{
+		{"不过期", 0, 0, 0, 0},
+		{"全局天数", 0, 0, 30, now.Add(-30 * day).UnixNano()},
+		{"会话天数优先", 7, 0, 30, now.Add(-7 * day).UnixNano()},
+		{"阅后即焚", 0, 60, 0, now.Add(-60 * time.Second).UnixNano()},
+		{"阅后即焚比天数更近", 7, 60, 30, now.Add(-60 * time.Second).UnixNano()},
+	}
+	for _, v := range tests {
+		setting := kcdb.ChatSetting{RetentionDays: v.retentionDays, DisappearSeconds: v.disappearSeconds}
+		got := retentionCutoff(&setting, v.globalDays, now)
+		if got != v.want {
+			t.Errorf("%s: 结果 %d, 应为 %d", v.name, got, v.want)
+		}
+	}
+}
@@ -0,0 +1,167 @@
+package kc
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"path/filepath"
+	"strings"
+	"time"
+
+	kcoption "github.com/alx696/polong-core/kc/option"
+	"github.com/libp2p/go-libp2p"
+	circuit "github.com/libp2p/go-libp2p-circuit"
+	connmgr "github.com/libp2p/go-libp2p-connmgr"
+	"github.com/libp2p/go-libp2p-core/host"
+	routing "github.com/libp2p/go-libp2p-core/routing"
+	dht "github.com/libp2p/go-libp2p-kad-dht"
+	libp2ptls "github.com/libp2p/go-libp2p-tls"
+)
+
+// 服务器模式标记(只提供引导和中继, 没有会话和联系人)
+var serverMode bool
+
+// 服务器启动时间
+var serverStartTime time.Time
+
+// ServerStats 服务器统计
+type ServerStats struct {
+	ID string `json:"id"`
+	// 监听地址
+	AddrArray []string `json:"addr_array"`
+	// 运行秒数
+	UptimeSeconds int64 `json:"uptime_seconds"`
+	// 节点数量
+	PeerCount int `json:"peer_count"`
+	// 连接数量
+	ConnCount int `json:"conn_count"`
+	// DHT路由表节点数量
+	RoutingTableSize int `json:"routing_table_size"`
+	// 正在中继的流数量
+	RelayStreamCount int `json:"relay_stream_count"`
+	// 中继流数量上限
+	RelayStreamLimit int `json:"relay_stream_limit"`
+}
+
+// StartServer 以服务器模式启动(引导和中继节点)
+// 身份密钥保存在安全目录中, DHT使用服务器模式, 开启中继(relayLimit为中继流数量上限, 0使用默认值).
+// bootstrapArrayText为其它引导地址(逗号分隔, 可以为空).
+// 注意: 这是阻塞方法, 只有报错或停止才会退出.
+func StartServer(safeDir string, port int, bootstrapArrayText string, relayLimit int) error {
+	log.Printf("服务器启动-安全目录:%s, 端口:%d", safeDir, port)
+
+	// 最先标记, 启动过程中或启动失败后停止时不会进入普通模式的停止流程
+	serverMode = true
+
+	bootstrapArray := []string{}
+	if bootstrapArrayText != "" {
+		bootstrapArray = strings.Split(bootstrapArrayText, ",")
+	}
+	for _, v := range bootstrapArray {
+		//检查地址
+		_, e := multiaddrToAddrInfo(v)
+		if e != nil {
+			return fmt.Errorf("破址错误: %s", v)
+		}
+	}
+	if relayLimit > 0 {
+		circuit.HopStreamLimit = relayLimit
+	}
+
+	// 加载(创建)密钥
+	privateKey, e := getPrivateKey(filepath.Join(safeDir, "pi.key"))
+	if e != nil {
+		return e
+	}
+
+	// 私有网络(团队服务器和客户端使用相同的密钥)
+	privateNetworkOptionArray, e := privateNetworkOptions(safeDir)
+	if e != nil {
+		return e
+	}
+
+	// 监听和传输(使用默认地址)
+	listenOptionArray, e := listenOptions(&kcoption.Option{}, port)
+	if e != nil {
+		return e
+	}
+	listenOptionArray = append(listenOptionArray, privateNetworkOptionArray...)
+
+	// 上下文控制libp2p节点的生命周期, 取消它可以停止节点.
+	ctx, ctxCancel = context.WithCancel(context.Background())
+
+	// 创建主机
+	h, e = libp2p.New(ctx, append(listenOptionArray,
+		libp2p.Identity(*privateKey),
+		libp2p.Security(libp2ptls.ID, libp2ptls.New),
+		libp2p.ConnectionManager(connmgr.NewConnManager(
+			1000,        // Lowwater
+			2000,        // HighWater,
+			time.Minute, // GracePeriod
+		)),
+		natPortMapOption(),
+		// 服务器有公网地址
+		libp2p.ForceReachabilityPublic(),
+		libp2p.Routing(func(h host.Host) (routing.PeerRouting, error) {
+			idht, e = dht.New(ctx, h, dht.Mode(dht.ModeServer))
+			return idht, e
+		}),
+		// 为其它节点中继
+		libp2p.EnableRelay(circuit.OptHop),
+	)...)
+	if e != nil {
+		return fmt.Errorf("创建主机出错: %w", e)
+	}
+	log.Println("服务器节点", h.ID().Pretty(), h.Addrs())
+
+	// 订阅网络事件(用于网络诊断)
+	e = watchNetworkEvent()
+	if e != nil {
+		return fmt.Errorf("订阅网络事件出错: %w", e)
+	}
+
+	// 连接其它引导节点
+	for _, v := range bootstrapArray {
+		go connectBootstrap(v)
+	}
+	e = idht.Bootstrap(ctx)
+	if e != nil {
+		return fmt.Errorf("启动DHT出错: %w", e)
+	}
+
+	// 标记就绪
+	serverStartTime = time.Now()
+	ready = true
+
+	// 保持运行
+	<-ctx.Done()
+	log.Println("服务器停止")
+	return nil
+}
+
+// GetServerStats 获取服务器统计, 返回ServerStats
+func GetServerStats() string {
+	stats := ServerStats{
+		ID:               h.ID().Pretty(),
+		AddrArray:        []string{},
+		UptimeSeconds:    int64(time.Since(serverStartTime).Seconds()),
+		PeerCount:        h.Peerstore().Peers().Len(),
+		ConnCount:        len(h.Network().Conns()),
+		RoutingTableSize: idht.RoutingTable().Size(),
+		RelayStreamLimit: circuit.HopStreamLimit,
+	}
+	for _, v := range h.Addrs() {
+		stats.AddrArray = append(stats.AddrArray, v.String())
+	}
+	for _, conn := range h.Network().Conns() {
+		for _, s := range conn.GetStreams() {
+			if s.Protocol() == circuit.ProtoID {
+				stats.RelayStreamCount++
+			}
+		}
+	}
+
+	jsonBytes, _ := json.Marshal(stats)
+	return string(jsonBytes)
+}
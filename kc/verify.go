@@ -0,0 +1,176 @@
+package kc
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	kccontact "github.com/alx696/polong-core/kc/contact"
+	"github.com/alx696/polong-core/qc"
+	"github.com/libp2p/go-libp2p-core/crypto"
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+// 安全码二维码内容前缀(后接节点ID和安全码, 用冒号分隔)
+const safetyNumberQRCodePrefix = "kc-verify:1:"
+
+// SafetyNumber 安全码
+type SafetyNumber struct {
+	// 联系人ID
+	ID string `json:"id"`
+	// 安全码(12组5位数字, 双方相同)
+	Number string `json:"number"`
+	// 二维码内容(对方扫描后核对)
+	QRCodeText string `json:"qrcode_text"`
+	// 是否已经核对
+	Verified bool `json:"verified"`
+}
+
+// 获取节点公钥
+func peerPublicKey(id string) (crypto.PubKey, error) {
+	peerID, e := peer.Decode(id)
+	if e != nil {
+		return nil, fmt.Errorf("id错误")
+	}
+	publicKey := h.Peerstore().PubKey(peerID)
+	if publicKey == nil {
+		return nil, fmt.Errorf("没有对方公钥, 请先连接对方")
+	}
+	return publicKey, nil
+}
+
+// 计算公钥指纹(SHA-256十六进制)
+func publicKeyFingerprint(publicKey crypto.PubKey) (string, error) {
+	keyBytes, e := crypto.MarshalPublicKey(publicKey)
+	if e != nil {
+		return "", e
+	}
+	sum := sha256.Sum256(keyBytes)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// 获取节点公钥指纹, 获取不到时返回空
+func peerKeyFingerprint(id string) string {
+	publicKey, e := peerPublicKey(id)
+	if e != nil {
+		return ""
+	}
+	fingerprint, _ := publicKeyFingerprint(publicKey)
+	return fingerprint
+}
+
+// 根据双方公钥计算安全码, 公钥排序后计算所以双方结果相同
+func safetyNumber(myKey, targetKey crypto.PubKey) (string, error) {
+	myBytes, e := crypto.MarshalPublicKey(myKey)
+	if e != nil {
+		return "", e
+	}
+	targetBytes, e := crypto.MarshalPublicKey(targetKey)
+	if e != nil {
+		return "", e
+	}
+	if bytes.Compare(myBytes, targetBytes) > 0 {
+		myBytes, targetBytes = targetBytes, myBytes
+	}
+
+	hash := sha512.New()
+	for _, v := range [][]byte{myBytes, targetBytes} {
+		lengthBytes := make([]byte, 4)
+		binary.BigEndian.PutUint32(lengthBytes, uint32(len(v)))
+		hash.Write(lengthBytes)
+		hash.Write(v)
+	}
+	sum := hash.Sum(nil)
+
+	// 每5字节转为5位数字, 共12组
+	groups := make([]string, 12)
+	for i := range groups {
+		chunk := make([]byte, 8)
+		copy(chunk[3:], sum[i*5:i*5+5])
+		groups[i] = fmt.Sprintf("%05d", binary.BigEndian.Uint64(chunk)%100000)
+	}
+	return strings.Join(groups, " "), nil
+}
+
+// 获取和联系人的安全码
+func contactSafetyNumber(id string) (*SafetyNumber, error) {
+	if !kccontact.Has(id) {
+		return nil, fmt.Errorf("联系人不存在")
+	}
+	targetKey, e := peerPublicKey(id)
+	if e != nil {
+		return nil, e
+	}
+	number, e := safetyNumber(h.Peerstore().PubKey(h.ID()), targetKey)
+	if e != nil {
+		return nil, e
+	}
+
+	qrCodeText := safetyNumberQRCodePrefix + h.ID().Pretty() + ":" + strings.ReplaceAll(number, " ", "")
+	return &SafetyNumber{ID: id, Number: number, QRCodeText: qrCodeText, Verified: kccontact.Get(id).Verified}, nil
+}
+
+// GetSafetyNumber 获取和联系人的安全码, 返回SafetyNumber
+func GetSafetyNumber(id string) (string, error) {
+	data, e := contactSafetyNumber(id)
+	if e != nil {
+		return "", e
+	}
+	jsonBytes, _ := json.Marshal(data)
+	return string(jsonBytes), nil
+}
+
+// EncodeSafetyNumberQRCode 生成和联系人的安全码二维码图片
+// 注意: 不要保存到文件目录中, 否则会被当作无引用文件清理.
+func EncodeSafetyNumberQRCode(id, path string) error {
+	data, e := contactSafetyNumber(id)
+	if e != nil {
+		return e
+	}
+	return qc.Encode(path, data.QRCodeText)
+}
+
+// SetContactVerified 设置联系人是否已经核对(当面比较安全码后设置)
+// 核对时记录对方公钥指纹, 之后指纹变化会取消核对.
+func SetContactVerified(id string, verified bool) error {
+	fingerprint := ""
+	if verified {
+		fingerprint = peerKeyFingerprint(id)
+		if fingerprint == "" {
+			return fmt.Errorf("没有对方公钥, 请先连接对方")
+		}
+	}
+
+	return updateContact(id, func(data *kccontact.Contact) {
+		data.Verified = verified
+		if fingerprint != "" {
+			data.KeyFingerprint = fingerprint
+		}
+	})
+}
+
+// VerifyContactQRCode 核对扫描到的对方安全码二维码, 一致时设置联系人已经核对
+func VerifyContactQRCode(id, text string) error {
+	if !strings.HasPrefix(text, safetyNumberQRCodePrefix) {
+		return fmt.Errorf("不是安全码二维码")
+	}
+	parts := strings.Split(strings.TrimPrefix(text, safetyNumberQRCodePrefix), ":")
+	if len(parts) != 2 || parts[0] != id {
+		return fmt.Errorf("二维码不属于此联系人")
+	}
+
+	data, e := contactSafetyNumber(id)
+	if e != nil {
+		return e
+	}
+	if parts[1] != strings.ReplaceAll(data.Number, " ", "") {
+		return fmt.Errorf("安全码不一致")
+	}
+
+	return SetContactVerified(id, true)
+}
@@ -0,0 +1,49 @@
+package kc
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/libp2p/go-libp2p-core/crypto"
+)
+
+func TestSafetyNumber(t *testing.T) {
+	var keys []crypto.PubKey
+	for i := 0; i < 3; i++ {
+		_, publicKey, e := crypto.GenerateKeyPair(crypto.Ed25519, -1)
+		if e != nil {
+			t.Fatal(e)
+		}
+		keys = append(keys, publicKey)
+	}
+
+	// 12组5位数字
+	format := regexp.MustCompile(`^\d{5}( \d{5}){11}$`)
+
+	tests := []struct {
+		name      string
+		myKey     crypto.PubKey
+		targetKey crypto.PubKey
+		sameAs    int // 与第几个结果相同, -1表示与之前的结果都不同
+	}{
+		{"我和对方", keys[0], keys[1], -1},
+		{"对方和我", keys[1], keys[0], 0},
+		{"我和其他人", keys[0], keys[2], -1},
+	}
+	var results []string
+	for _, v := range tests {
+		number, e := safetyNumber(v.myKey, v.targetKey)
+		if e != nil {
+			t.Fatal(v.name, e)
+		}
+		if !format.MatchString(number) {
+			t.Errorf("%s: 格式错误 %s", v.name, number)
+		}
+		for i, result := range results {
+			if (i == v.sameAs) != (result == number) {
+				t.Errorf("%s: 和第%d个结果比较错误 %s, %s", v.name, i, number, result)
+			}
+		}
+		results = append(results, number)
+	}
+}
@@ -40,8 +40,24 @@ func main() {
 	fileDirectoryFlag := flag.String("file-directory", "", "file directory path")
 	p2pPortFlag := flag.Int("p2p-port", 10000, "p2p port")
 	webPortFlag := flag.Int("web-port", 10001, "web port")
+	dbPassphraseFlag := flag.String("db-passphrase", "", "database passphrase, derived from node identity when empty")
+	serverFlag := flag.Bool("server", false, "run as bootstrap/relay server without chat and contacts")
+	serverBootstrapFlag := flag.String("server-bootstrap", "", "server mode: other bootstrap multiaddrs, comma separated")
+	relayLimitFlag := flag.Int("relay-limit", 0, "server mode: max relay streams, default when 0")
+	swarmKeyGenerateFlag := flag.Bool("swarm-key-generate", false, "print a new private network key and exit")
+	swarmKeyFlag := flag.String("swarm-key", "", "import private network key file into safe directory")
 	flag.Parse()
 
+	// 生成私有网络密钥
+	if *swarmKeyGenerateFlag {
+		keyText, e := kc.GenerateSwarmKey()
+		if e != nil {
+			log.Fatalln("生成私有网络密钥出错", e)
+		}
+		fmt.Print(keyText)
+		return
+	}
+
 	//获取用户配置文件夹
 	configDir, e := os.UserConfigDir()
 	if e != nil {
@@ -60,16 +76,40 @@ func main() {
 	}
 	os.MkdirAll(fileDirectory, os.ModePerm)
 
-	// 启动
-	go func() {
-		e := kc.Start(safeDirectory, fileDirectory, *p2pPortFlag, FeedCallbackImpl{})
+	// 导入私有网络密钥
+	if *swarmKeyFlag != "" {
+		keyBytes, e := ioutil.ReadFile(*swarmKeyFlag)
+		if e == nil {
+			e = kc.SetSwarmKey(safeDirectory, string(keyBytes))
+		}
 		if e != nil {
-			log.Fatalln("启动失败:", e)
+			log.Fatalln("导入私有网络密钥出错", e)
 		}
-	}()
+	}
+
+	// 启动
+	if *serverFlag {
+		go func() {
+			e := kc.StartServer(safeDirectory, *p2pPortFlag, *serverBootstrapFlag, *relayLimitFlag)
+			if e != nil {
+				log.Fatalln("启动失败:", e)
+			}
+		}()
+
+		// 启动http(只有统计)
+		go startServerHTTP(*webPortFlag)
+	} else {
+		kc.SetDatabasePassphrase(*dbPassphraseFlag)
+		go func() {
+			e := kc.Start(safeDirectory, fileDirectory, *p2pPortFlag, FeedCallbackImpl{})
+			if e != nil {
+				log.Fatalln("启动失败:", e)
+			}
+		}()
 
-	// 启动http
-	go startHTTP(*webPortFlag)
+		// 启动http
+		go startHTTP(*webPortFlag)
+	}
 
 	signalChan := make(chan os.Signal, 1)
 	signal.Notify(signalChan, syscall.SIGKILL, syscall.SIGINT, syscall.SIGTERM)
@@ -80,6 +120,42 @@ func main() {
 	kc.Stop()
 }
 
+// 服务器模式http
+func startServerHTTP(webPort int) {
+	mux := http.NewServeMux()
+
+	// 获取节点ID
+	mux.HandleFunc("/api1/id", func(writer http.ResponseWriter, request *http.Request) {
+		writer.Write([]byte(kc.GetID()))
+	})
+
+	// 服务器统计
+	mux.HandleFunc("/api1/server/stats", func(writer http.ResponseWriter, request *http.Request) {
+		if kc.GetID() == "" {
+			writer.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		result := kc.GetServerStats()
+		writer.Header().Set("Content-Type", "application/json")
+		writer.Write([]byte(result))
+	})
+
+	mux.HandleFunc("/api1/network/diagnostics", func(writer http.ResponseWriter, request *http.Request) {
+		if kc.GetID() == "" {
+			writer.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		result := kc.GetNetworkDiagnostics()
+		writer.Header().Set("Content-Type", "application/json")
+		writer.Write([]byte(result))
+	})
+
+	// 监听http
+	log.Fatalln(http.ListenAndServe(fmt.Sprint(":", webPort), mux))
+}
+
 func startHTTP(webPort int) {
 	// 获取节点ID
 	http.HandleFunc("/api1/id", func(writer http.ResponseWriter, request *http.Request) {
@@ -105,6 +181,21 @@ func startHTTP(webPort int) {
 		writer.Write([]byte(result))
 	})
 
+	// 通知网络变化(立即重新连接联系人)
+	http.HandleFunc("/api1/network/change", func(writer http.ResponseWriter, request *http.Request) {
+		if request.Method == "POST" {
+			kc.NotifyNetworkChange()
+		}
+	})
+
+	// 获取网络诊断
+	http.HandleFunc("/api1/network/diagnostics", func(writer http.ResponseWriter, request *http.Request) {
+		result := kc.GetNetworkDiagnostics()
+
+		writer.Header().Set("Content-Type", "application/json")
+		writer.Write([]byte(result))
+	})
+
 	// 获取我的设置
 	http.HandleFunc("/api1/option", func(writer http.ResponseWriter, request *http.Request) {
 		result := kc.GetOption()
@@ -133,6 +224,118 @@ func startHTTP(webPort int) {
 		}
 	})
 
+	// 内置引导地址
+	http.HandleFunc("/api1/option/bootstrap/default", func(writer http.ResponseWriter, request *http.Request) {
+		if request.Method == "GET" {
+			result := kc.GetDefaultBootstrapArray()
+
+			writer.Header().Set("Content-Type", "application/json")
+			writer.Write([]byte(result))
+		} else if request.Method == "POST" {
+			disabled := request.FormValue("disabled") == "true"
+
+			e := kc.SetDefaultBootstrapDisabled(disabled)
+			if e != nil {
+				writer.WriteHeader(http.StatusInternalServerError)
+				_, _ = writer.Write([]byte(e.Error()))
+				return
+			}
+		}
+	})
+
+	// 引导地址状态
+	http.HandleFunc("/api1/bootstrap", func(writer http.ResponseWriter, request *http.Request) {
+		result := kc.GetBootstrapState()
+
+		writer.Header().Set("Content-Type", "application/json")
+		writer.Write([]byte(result))
+	})
+
+	// 私有网络密钥(重新启动后生效)
+	http.HandleFunc("/api1/option/swarmkey", func(writer http.ResponseWriter, request *http.Request) {
+		if request.Method == "GET" {
+			// 生成
+			keyText, e := kc.GenerateSwarmKey()
+			if e != nil {
+				writer.WriteHeader(http.StatusInternalServerError)
+				_, _ = writer.Write([]byte(e.Error()))
+				return
+			}
+			writer.Write([]byte(keyText))
+		} else if request.Method == "POST" {
+			// 导入(为空时退出私有网络)
+			keyText := request.FormValue("key")
+
+			e := kc.SetSwarmKey(safeDirectory, keyText)
+			if e != nil {
+				writer.WriteHeader(http.StatusBadRequest)
+				_, _ = writer.Write([]byte(e.Error()))
+				return
+			}
+		}
+	})
+
+	// 设置监听地址(重新启动后生效)
+	http.HandleFunc("/api1/option/listen", func(writer http.ResponseWriter, request *http.Request) {
+		arrayText := request.FormValue("arrayText")
+
+		e := kc.SetListenAddrArray(arrayText)
+		if e != nil {
+			writer.WriteHeader(http.StatusBadRequest)
+			_, _ = writer.Write([]byte(e.Error()))
+			return
+		}
+	})
+
+	// 设置启用的传输(重新启动后生效)
+	http.HandleFunc("/api1/option/transport", func(writer http.ResponseWriter, request *http.Request) {
+		arrayText := request.FormValue("arrayText")
+
+		e := kc.SetTransportArray(arrayText)
+		if e != nil {
+			writer.WriteHeader(http.StatusBadRequest)
+			_, _ = writer.Write([]byte(e.Error()))
+			return
+		}
+	})
+
+	// 设置会话消息保留天数
+	http.HandleFunc("/api1/option/retention", func(writer http.ResponseWriter, request *http.Request) {
+		days, e := strconv.ParseInt(request.FormValue("days"), 10, 64)
+		if e == nil {
+			e = kc.SetRetentionDays(days)
+		}
+		if e != nil {
+			writer.WriteHeader(http.StatusBadRequest)
+			_, _ = writer.Write([]byte(e.Error()))
+			return
+		}
+	})
+
+	// 设置隐私模式
+	http.HandleFunc("/api1/option/privacy", func(writer http.ResponseWriter, request *http.Request) {
+		mode := request.FormValue("mode")
+
+		e := kc.SetPrivacyMode(mode)
+		if e != nil {
+			writer.WriteHeader(http.StatusBadRequest)
+			_, _ = writer.Write([]byte(e.Error()))
+			return
+		}
+	})
+
+	// 设置允许名单
+	http.HandleFunc("/api1/option/allowlist", func(writer http.ResponseWriter, request *http.Request) {
+		arrayText := request.FormValue("arrayText")
+
+		e := kc.SetAllowlistIDArray(arrayText)
+		if e != nil {
+			writer.WriteHeader(http.StatusBadRequest)
+			_, _ = writer.Write([]byte(e.Error()))
+			return
+		}
+	})
+
 	// 设置黑名单
 	http.HandleFunc("/api1/option/blacklist", func(writer http.ResponseWriter, request *http.Request) {
 		arrayText := request.FormValue("arrayText")
@@ -140,6 +343,96 @@ func startHTTP(webPort int) {
 		kc.SetBlacklistIDArray(arrayText)
 	})
 
+	// 拒绝名单
+	http.HandleFunc("/api1/block", func(writer http.ResponseWriter, request *http.Request) {
+		if request.Method == "GET" {
+			result := kc.GetBlockList()
+
+			writer.Header().Set("Content-Type", "application/json")
+			writer.Write([]byte(result))
+		} else if request.Method == "POST" {
+			id := request.FormValue("id")
+			reason := request.FormValue("reason")
+			keepHistory := request.FormValue("keepHistory") == "true"
+			var expireSeconds int64
+			if v := request.FormValue("expireSeconds"); v != "" {
+				var e error
+				expireSeconds, e = strconv.ParseInt(v, 10, 64)
+				if e != nil {
+					writer.WriteHeader(http.StatusBadRequest)
+					return
+				}
+			}
+
+			if id == "" {
+				writer.WriteHeader(http.StatusBadRequest)
+				return
+			}
+
+			result, e := kc.BlockPeer(id, reason, expireSeconds, keepHistory)
+			if e != nil {
+				writer.WriteHeader(http.StatusInternalServerError)
+				_, _ = writer.Write([]byte(e.Error()))
+				return
+			}
+			writer.Header().Set("Content-Type", "application/json")
+			writer.Write([]byte(result))
+		} else if request.Method == "DELETE" {
+			id := request.URL.Query().Get("id")
+
+			if id == "" {
+				writer.WriteHeader(http.StatusBadRequest)
+				return
+			}
+
+			e := kc.UnblockPeer(id)
+			if e != nil {
+				writer.WriteHeader(http.StatusInternalServerError)
+				_, _ = writer.Write([]byte(e.Error()))
+				return
+			}
+		}
+	})
+
+	// 拒绝记录
+	http.HandleFunc("/api1/block/attempt", func(writer http.ResponseWriter, request *http.Request) {
+		peerID := request.URL.Query().Get("peerID")
+
+		if request.Method == "GET" {
+			limit, _ := strconv.Atoi(request.URL.Query().Get("limit"))
+
+			result, e := kc.GetBlockAttempt(peerID, limit)
+			if e != nil {
+				writer.WriteHeader(http.StatusInternalServerError)
+				_, _ = writer.Write([]byte(e.Error()))
+				return
+			}
+			writer.Header().Set("Content-Type", "application/json")
+			writer.Write([]byte(result))
+		} else if request.Method == "DELETE" {
+			e := kc.DeleteBlockAttempt(peerID)
+			if e != nil {
+				writer.WriteHeader(http.StatusInternalServerError)
+				_, _ = writer.Write([]byte(e.Error()))
+				return
+			}
+		}
+	})
+
+	// 更换数据库口令
+	http.HandleFunc("/api1/option/db/passphrase", func(writer http.ResponseWriter, request *http.Request) {
+		if request.Method == "POST" {
+			passphrase := request.FormValue("passphrase")
+
+			e := kc.RekeyDatabase(passphrase)
+			if e != nil {
+				writer.WriteHeader(http.StatusInternalServerError)
+				_, _ = writer.Write([]byte(e.Error()))
+				return
+			}
+		}
+	})
+
 	// 订阅推送
 	http.HandleFunc("/api1/feed", func(writer http.ResponseWriter, request *http.Request) {
 		conn, e := websocketUpgrader.Upgrade(writer, request, nil)
@@ -164,7 +457,13 @@ func startHTTP(webPort int) {
 	// 联系人
 	http.HandleFunc("/api1/contact", func(writer http.ResponseWriter, request *http.Request) {
 		if request.Method == "GET" {
+			tag := request.URL.Query().Get("tag")
+			favorite := request.URL.Query().Get("favorite") == "true"
+
 			result := kc.GetContact()
+			if tag != "" || favorite {
+				result = kc.FindContact(tag, favorite)
+			}
 
 			writer.Header().Set("Content-Type", "application/json")
 			writer.Write([]byte(result))
@@ -196,6 +495,46 @@ func startHTTP(webPort int) {
 		}
 	})
 
+	// 联系请求(GET获取, POST同意, DELETE拒绝)
+	http.HandleFunc("/api1/contact/request", func(writer http.ResponseWriter, request *http.Request) {
+		if request.Method == "GET" {
+			result := kc.GetContactRequest()
+
+			writer.Header().Set("Content-Type", "application/json")
+			writer.Write([]byte(result))
+		} else if request.Method == "POST" {
+			id := request.FormValue("id")
+
+			if id == "" {
+				writer.WriteHeader(http.StatusBadRequest)
+				return
+			}
+
+			result, e := kc.AcceptContact(id)
+			if e != nil {
+				writer.WriteHeader(http.StatusInternalServerError)
+				_, _ = writer.Write([]byte(e.Error()))
+				return
+			}
+			writer.Header().Set("Content-Type", "application/json")
+			writer.Write([]byte(result))
+		} else if request.Method == "DELETE" {
+			id := request.URL.Query().Get("id")
+
+			if id == "" {
+				writer.WriteHeader(http.StatusBadRequest)
+				return
+			}
+
+			e := kc.RejectContact(id)
+			if e != nil {
+				writer.WriteHeader(http.StatusInternalServerError)
+				_, _ = writer.Write([]byte(e.Error()))
+				return
+			}
+		}
+	})
+
 	// 联系人设置备注
 	http.HandleFunc("/api1/contact/name/remark", func(writer http.ResponseWriter, request *http.Request) {
 		if request.Method == "POST" {
@@ -211,6 +550,154 @@ func startHTTP(webPort int) {
 		}
 	})
 
+	// 联系人标签(GET获取所有标签, POST添加, PUT设置或重命名, DELETE移除)
+	http.HandleFunc("/api1/contact/tag", func(writer http.ResponseWriter, request *http.Request) {
+		var e error
+		if request.Method == "GET" {
+			result := kc.GetContactTags()
+
+			writer.Header().Set("Content-Type", "application/json")
+			writer.Write([]byte(result))
+			return
+		} else if request.Method == "POST" {
+			id := request.FormValue("id")
+			tag := request.FormValue("tag")
+
+			if id == "" || tag == "" {
+				writer.WriteHeader(http.StatusBadRequest)
+				return
+			}
+
+			e = kc.AddContactTag(id, tag)
+		} else if request.Method == "PUT" {
+			id := request.FormValue("id")
+			oldTag := request.FormValue("oldTag")
+			newTag := request.FormValue("newTag")
+
+			if id != "" {
+				e = kc.SetContactTags(id, request.FormValue("tags"))
+			} else if oldTag != "" && newTag != "" {
+				e = kc.RenameContactTag(oldTag, newTag)
+			} else {
+				writer.WriteHeader(http.StatusBadRequest)
+				return
+			}
+		} else if request.Method == "DELETE" {
+			id := request.URL.Query().Get("id")
+			tag := request.URL.Query().Get("tag")
+
+			if tag == "" {
+				writer.WriteHeader(http.StatusBadRequest)
+				return
+			}
+
+			if id != "" {
+				e = kc.RemoveContactTag(id, tag)
+			} else {
+				e = kc.DeleteContactTag(tag)
+			}
+		}
+
+		if e != nil {
+			writer.WriteHeader(http.StatusInternalServerError)
+			_, _ = writer.Write([]byte(e.Error()))
+			return
+		}
+	})
+
+	// 联系人收藏
+	http.HandleFunc("/api1/contact/favorite", func(writer http.ResponseWriter, request *http.Request) {
+		if request.Method == "POST" {
+			id := request.FormValue("id")
+			favorite := request.FormValue("favorite") == "true"
+
+			if id == "" {
+				writer.WriteHeader(http.StatusBadRequest)
+				return
+			}
+
+			e := kc.SetContactFavorite(id, favorite)
+			if e != nil {
+				writer.WriteHeader(http.StatusInternalServerError)
+				_, _ = writer.Write([]byte(e.Error()))
+				return
+			}
+		}
+	})
+
+	// 联系人安全码核对
+	http.HandleFunc("/api1/contact/verify", func(writer http.ResponseWriter, request *http.Request) {
+		if request.Method == "GET" {
+			id := request.URL.Query().Get("id")
+
+			if id == "" {
+				writer.WriteHeader(http.StatusBadRequest)
+				return
+			}
+
+			// 获取二维码图片供对方扫描(使用临时文件, 不放在文件目录中)
+			if request.URL.Query().Get("image") == "true" {
+				imageFile, e := ioutil.TempFile("", "verify-*.jpg")
+				if e != nil {
+					writer.WriteHeader(http.StatusInternalServerError)
+					_, _ = writer.Write([]byte(e.Error()))
+					return
+				}
+				imageFile.Close()
+				defer os.Remove(imageFile.Name())
+
+				e = kc.EncodeSafetyNumberQRCode(id, imageFile.Name())
+				if e != nil {
+					writer.WriteHeader(http.StatusInternalServerError)
+					_, _ = writer.Write([]byte(e.Error()))
+					return
+				}
+				imageBytes, e := ioutil.ReadFile(imageFile.Name())
+				if e != nil {
+					writer.WriteHeader(http.StatusInternalServerError)
+					_, _ = writer.Write([]byte(e.Error()))
+					return
+				}
+
+				writer.Header().Set("Content-Type", "image/jpeg")
+				writer.Write(imageBytes)
+				return
+			}
+
+			result, e := kc.GetSafetyNumber(id)
+			if e != nil {
+				writer.WriteHeader(http.StatusInternalServerError)
+				_, _ = writer.Write([]byte(e.Error()))
+				return
+			}
+
+			writer.Header().Set("Content-Type", "application/json")
+			writer.Write([]byte(result))
+		} else if request.Method == "POST" {
+			id := request.FormValue("id")
+			text := request.FormValue("text")
+			verified := request.FormValue("verified") == "true"
+
+			if id == "" {
+				writer.WriteHeader(http.StatusBadRequest)
+				return
+			}
+
+			// 提供二维码内容时核对二维码, 否则直接设置
+			var e error
+			if text != "" {
+				e = kc.VerifyContactQRCode(id, text)
+			} else {
+				e = kc.SetContactVerified(id, verified)
+			}
+			if e != nil {
+				writer.WriteHeader(http.StatusInternalServerError)
+				_, _ = writer.Write([]byte(e.Error()))
+				return
+			}
+		}
+	})
+
 	// 获取连接信息
 	http.HandleFunc("/api1/contact/connect", func(writer http.ResponseWriter, request *http.Request) {
 		result := kc.GetConnectedPeerIDs()
@@ -219,6 +706,14 @@ func startHTTP(webPort int) {
 		writer.Write([]byte(result))
 	})
 
+	// 获取连接详情(传输, 中继, 延迟)
+	http.HandleFunc("/api1/contact/connection", func(writer http.ResponseWriter, request *http.Request) {
+		result := kc.GetPeerConnections()
+
+		writer.Header().Set("Content-Type", "application/json")
+		writer.Write([]byte(result))
+	})
+
 	// 文件(供无法直接访问文件系统的客户端使用)
 	http.HandleFunc("/api1/file", func(writer http.ResponseWriter, request *http.Request) {
 		if request.Method == "POST" {
@@ -279,6 +774,20 @@ func startHTTP(webPort int) {
 		}
 	})
 
+	// 无引用文件(GET查找, DELETE删除)
+	http.HandleFunc("/api1/file/orphan", func(writer http.ResponseWriter, request *http.Request) {
+		if request.Method == "GET" || request.Method == "DELETE" {
+			result, e := kc.CollectOrphanFiles(request.Method == "DELETE")
+			if e != nil {
+				writer.WriteHeader(http.StatusInternalServerError)
+				_, _ = writer.Write([]byte(e.Error()))
+				return
+			}
+			writer.Header().Set("Content-Type", "application/json")
+			writer.Write([]byte(result))
+		}
+	})
+
 	// 会话消息
 	http.HandleFunc("/api1/chat/message", func(writer http.ResponseWriter, request *http.Request) {
 		if request.Method == "POST" {
@@ -312,13 +821,61 @@ func startHTTP(webPort int) {
 			writer.Write([]byte(result))
 		} else if request.Method == "DELETE" {
 			peerID := request.URL.Query().Get("peerID")
+			id, _ := strconv.ParseInt(request.URL.Query().Get("id"), 10, 64)
+			deleteFile := request.URL.Query().Get("deleteFile") == "true"
 
-			if peerID == "" {
+			if peerID == "" && id == 0 {
 				writer.WriteHeader(http.StatusBadRequest)
 				return
 			}
 
-			kc.DeleteChatMessageByPeerID(peerID)
+			if id != 0 && deleteFile {
+				kc.DeleteChatMessageAndFileByID(id)
+			} else if id != 0 {
+				kc.DeleteChatMessageByID(id)
+			} else if deleteFile {
+				kc.DeleteChatMessageAndFileByPeerID(peerID)
+			} else {
+				kc.DeleteChatMessageByPeerID(peerID)
+			}
+		}
+	})
+
+	// 名片消息
+	http.HandleFunc("/api1/chat/message/contact", func(writer http.ResponseWriter, request *http.Request) {
+		if request.Method == "POST" {
+			// 发送名片
+			peerID := request.FormValue("peerID")
+			contactID := request.FormValue("contactID")
+
+			if peerID == "" || contactID == "" {
+				writer.WriteHeader(http.StatusBadRequest)
+				return
+			}
+
+			e := kc.SendChatMessageContact(peerID, contactID)
+			if e != nil {
+				writer.WriteHeader(http.StatusInternalServerError)
+				_, _ = writer.Write([]byte(e.Error()))
+				return
+			}
+		} else if request.Method == "PUT" {
+			// 添加名片中的联系人
+			id, _ := strconv.ParseInt(request.FormValue("id"), 10, 64)
+
+			if id == 0 {
+				writer.WriteHeader(http.StatusBadRequest)
+				return
+			}
+
+			result, e := kc.AddContactFromChatMessage(id)
+			if e != nil {
+				writer.WriteHeader(http.StatusInternalServerError)
+				_, _ = writer.Write([]byte(e.Error()))
+				return
+			}
+			writer.Header().Set("Content-Type", "application/json")
+			writer.Write([]byte(result))
 		}
 	})
 
@@ -340,6 +897,99 @@ func startHTTP(webPort int) {
 		}
 	})
 
+	// 会话设置
+	http.HandleFunc("/api1/chat/setting", func(writer http.ResponseWriter, request *http.Request) {
+		if request.Method == "POST" {
+			peerID := request.FormValue("peerID")
+
+			if peerID == "" {
+				writer.WriteHeader(http.StatusBadRequest)
+				return
+			}
+
+			// 检查参数
+			var days, seconds int64
+			var e error
+			retentionDaysText := request.FormValue("retentionDays")
+			if retentionDaysText != "" {
+				days, e = strconv.ParseInt(retentionDaysText, 10, 64)
+			}
+			disappearSecondsText := request.FormValue("disappearSeconds")
+			if e == nil && disappearSecondsText != "" {
+				seconds, e = strconv.ParseInt(disappearSecondsText, 10, 64)
+			}
+			if e != nil {
+				writer.WriteHeader(http.StatusBadRequest)
+				_, _ = writer.Write([]byte(e.Error()))
+				return
+			}
+
+			if retentionDaysText != "" {
+				e = kc.SetChatRetentionDays(peerID, days)
+			}
+			if e == nil && disappearSecondsText != "" {
+				e = kc.SetChatDisappearSeconds(peerID, seconds)
+			}
+			if e != nil {
+				writer.WriteHeader(http.StatusInternalServerError)
+				_, _ = writer.Write([]byte(e.Error()))
+				return
+			}
+		} else if request.Method == "GET" {
+			peerID := request.URL.Query().Get("peerID")
+
+			if peerID == "" {
+				writer.WriteHeader(http.StatusBadRequest)
+				return
+			}
+
+			result, e := kc.GetChatSetting(peerID)
+			if e != nil {
+				writer.WriteHeader(http.StatusInternalServerError)
+				_, _ = writer.Write([]byte(e.Error()))
+				return
+			}
+			writer.Header().Set("Content-Type", "application/json")
+			writer.Write([]byte(result))
+		}
+	})
+
+	// 会话存档
+	http.HandleFunc("/api1/chat/archive", func(writer http.ResponseWriter, request *http.Request) {
+		if request.Method == "POST" {
+			peerID := request.FormValue("peerID")
+			format := request.FormValue("format")
+			path := request.FormValue("path")
+
+			if peerID == "" || path == "" {
+				writer.WriteHeader(http.StatusBadRequest)
+				return
+			}
+
+			e := kc.ExportConversation(peerID, format, path)
+			if e != nil {
+				writer.WriteHeader(http.StatusInternalServerError)
+				_, _ = writer.Write([]byte(e.Error()))
+				return
+			}
+		} else if request.Method == "PUT" {
+			path := request.FormValue("path")
+
+			if path == "" {
+				writer.WriteHeader(http.StatusBadRequest)
+				return
+			}
+
+			count, e := kc.ImportConversation(path)
+			if e != nil {
+				writer.WriteHeader(http.StatusInternalServerError)
+				_, _ = writer.Write([]byte(e.Error()))
+				return
+			}
+			_, _ = writer.Write([]byte(strconv.Itoa(count)))
+		}
+	})
+
 	// 二维码
 	http.HandleFunc("/api1/qrcode", func(writer http.ResponseWriter, request *http.Request) {
 		if request.Method == "GET" {
@@ -413,6 +1063,20 @@ func (impl FeedCallbackImpl) FeedCallbackOnContactDelete(id string) {
 	}
 }
 
+func (impl FeedCallbackImpl) FeedCallbackOnContactRequest(text string) {
+	if websocketConn == nil {
+		return
+	}
+
+	push := PushInfo{Type: "ContactRequest", Text: text}
+	jsonBytes, _ := json.Marshal(push)
+
+	e := websocketConn.WriteMessage(websocket.TextMessage, jsonBytes)
+	if e != nil {
+		log.Println("WebSocket出错", e)
+	}
+}
+
 func (impl FeedCallbackImpl) FeedCallbackOnPeerConnectState(id string, isConnect bool) {
 	if websocketConn == nil {
 		return
@@ -427,6 +1091,20 @@ func (impl FeedCallbackImpl) FeedCallbackOnPeerConnectState(id string, isConnect
 	}
 }
 
+func (impl FeedCallbackImpl) FeedCallbackOnPeerConnection(text string) {
+	if websocketConn == nil {
+		return
+	}
+
+	push := PushInfo{Type: "PeerConnection", Text: text}
+	jsonBytes, _ := json.Marshal(push)
+
+	e := websocketConn.WriteMessage(websocket.TextMessage, jsonBytes)
+	if e != nil {
+		log.Println("WebSocket出错", e)
+	}
+}
+
 func (impl FeedCallbackImpl) FeedCallbackOnChatMessage(peerID string, chatMessage string) {
 	if websocketConn == nil {
 		return
@@ -455,6 +1133,34 @@ func (impl FeedCallbackImpl) FeedCallbackOnChatMessageState(peerID string, messa
 	}
 }
 
+func (impl FeedCallbackImpl) FeedCallbackOnChatMessageDelete(peerID string, messageID int64) {
+	if websocketConn == nil {
+		return
+	}
+
+	push := PushInfo{Type: "ChatMessageDelete", ID: peerID, MessageID: messageID}
+	jsonBytes, _ := json.Marshal(push)
+
+	e := websocketConn.WriteMessage(websocket.TextMessage, jsonBytes)
+	if e != nil {
+		log.Println("WebSocket出错", e)
+	}
+}
+
+func (impl FeedCallbackImpl) FeedCallbackOnChatSettingUpdate(peerID string, setting string) {
+	if websocketConn == nil {
+		return
+	}
+
+	push := PushInfo{Type: "ChatSettingUpdate", ID: peerID, Text: setting}
+	jsonBytes, _ := json.Marshal(push)
+
+	e := websocketConn.WriteMessage(websocket.TextMessage, jsonBytes)
+	if e != nil {
+		log.Println("WebSocket出错", e)
+	}
+}
+
 func (impl FeedCallbackImpl) FeedCallbackOnRemoteControlRequest(peerID string) {
 	//
 }